@@ -2,30 +2,71 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/vinodhalaharvi/go2proto/pkg/buf"
+	"github.com/vinodhalaharvi/go2proto/pkg/ct"
+	"github.com/vinodhalaharvi/go2proto/pkg/descriptor"
 	"github.com/vinodhalaharvi/go2proto/pkg/generator"
 	"github.com/vinodhalaharvi/go2proto/pkg/parser"
 	"github.com/vinodhalaharvi/go2proto/pkg/transformer"
 )
 
 var (
-	version        = "0.1.0"
-	outDir         = flag.String("out", ".", "Output directory for .proto files")
-	protoPackage   = flag.String("package", "", "Proto package name (default: derived from Go package)")
-	goPackage      = flag.String("go_package", "", "go_package option (default: same as Go import path)")
-	includePrivate = flag.Bool("private", false, "Include unexported fields")
-	oneFile        = flag.Bool("one-file", false, "Generate a single .proto file for all packages")
-	fileName       = flag.String("filename", "", "Output filename (only with -one-file)")
-	showVersion    = flag.Bool("version", false, "Show version")
-	verbose        = flag.Bool("v", false, "Verbose output")
+	version          = "0.1.0"
+	outDir           = flag.String("out", ".", "Output directory for .proto files")
+	protoPackage     = flag.String("package", "", "Proto package name (default: derived from Go package)")
+	goPackage        = flag.String("go_package", "", "go_package option (default: same as Go import path)")
+	includePrivate   = flag.Bool("private", false, "Include unexported fields")
+	oneFile          = flag.Bool("one-file", false, "Generate a single .proto file for all packages")
+	fileName         = flag.String("filename", "", "Output filename (only with -one-file)")
+	layout           = flag.String("layout", "flat", "Output layout: flat (<package>.proto), package (directories following the proto package) or file (one .proto per Go file)")
+	mergeCycles      = flag.Bool("merge-cycles", false, "With -layout=file, merge Go files that would import each other into one .proto")
+	renameEnumZero   = flag.Bool("rename-enum-zero", false, "Rename zero enum constants to <ENUM>_UNSPECIFIED")
+	dropAliases      = flag.Bool("drop-enum-aliases", false, "Keep one name per enum number instead of emitting allow_alias")
+	flagStyle        = flag.String("flags-style", "enum", "How to document bit-flag constants: enum or comment")
+	messageNaming    = flag.String("message-naming", "service", "Resolve request/response name clashes by service prefix or numeric suffix: service or suffix")
+	syntax           = flag.String("syntax", "proto3", "Output syntax: proto2, proto3 or editions")
+	edition          = flag.String("edition", transformer.DefaultEdition, "Edition written with -syntax=editions")
+	ordering         = flag.String("order", "source", "Declaration order: source (file, then line), alphabetical or topological")
+	wrapRPC          = flag.Bool("wrap-rpc-messages", false, "Give every RPC its own Request and Response message")
+	errorStatus      = flag.Bool("error-status", false, "Map error-typed fields to google.rpc.Status instead of string")
+	ignoreParams     = flag.String("ignore-param-types", "", "Extra comma-separated parameter types to leave out of requests (e.g. net/http.Header)")
+	funcOptions      = flag.Bool("skip-func-options", true, "Leave variadic function-typed parameters out of requests")
+	managed          = flag.Bool("managed", false, "Derive java_package, csharp_namespace and other language options from the proto package")
+	bufConfig        = flag.Bool("buf", false, "Write or update buf.yaml, and a starter buf.gen.yaml, in -buf-dir")
+	bufDir           = flag.String("buf-dir", ".", "Directory for buf.yaml and buf.gen.yaml (with -buf)")
+	descriptorSetOut = flag.String("descriptor_set_out", "", "Also write a binary FileDescriptorSet of the generated files to this path")
+	commentWidth     = flag.Int("comment-width", 0, "Wrap comment paragraphs at this column (0: keep line breaks)")
+	align            = flag.Bool("align", false, "Align field names and numbers in columns (buf format undoes this)")
+	showVersion      = flag.Bool("version", false, "Show version")
+	verbose          = flag.Bool("v", false, "Verbose output")
 )
 
+// optionFlags collects repeated -option name=value flags.
+type optionFlags map[string]transformer.OptionValue
+
+func (o optionFlags) String() string { return "" }
+
+func (o optionFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	o[strings.TrimSpace(name)] = transformer.ParseOptionValue(strings.TrimSpace(value))
+	return nil
+}
+
+var fileOptions = optionFlags{}
+
 func main() {
+	flag.Var(fileOptions, "option", "File option name=value, repeatable (e.g. optimize_for=SPEED, java_multiple_files=true)")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "go2proto - Generate Protocol Buffer definitions from Go source code\n\n")
 		fmt.Fprintf(os.Stderr, "Usage: go2proto [flags] <packages...>\n\n")
@@ -39,6 +80,23 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  // +go2proto=false      Skip this type\n")
 		fmt.Fprintf(os.Stderr, "  // +go2proto:service    Generate interface as gRPC service\n")
 		fmt.Fprintf(os.Stderr, "  // +go2proto:enum       Generate type alias as enum\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:errors=NotFoundError,ValidationError\n")
+		fmt.Fprintf(os.Stderr, "                          Register a service's google.rpc.Status detail types\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:flags      Treat constants of this type as bit flags\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:oneof=name Group struct field into oneof block\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:option:json_name=userId\n")
+		fmt.Fprintf(os.Stderr, "                          Add an option to the documented element\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:import=acme/options.proto\n")
+		fmt.Fprintf(os.Stderr, "                          Import the file defining a custom option\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:name=Name  Rename a service method's RPC\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:request=T / +go2proto:response=T\n")
+		fmt.Fprintf(os.Stderr, "                          Set a service method's request or response type\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:metadata=traceID,tenant\n")
+		fmt.Fprintf(os.Stderr, "                          Document method params as headers, not request fields\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:idempotency_level=NO_SIDE_EFFECTS\n")
+		fmt.Fprintf(os.Stderr, "                          Set a service method's idempotency level\n")
+		fmt.Fprintf(os.Stderr, "  // +go2proto:http=GET /v1/users/{id} body=*\n")
+		fmt.Fprintf(os.Stderr, "                          Add google.api.http option to a service method\n")
 	}
 
 	flag.Parse()
@@ -60,6 +118,9 @@ func main() {
 }
 
 func run(patterns []string) error {
+	if err := checkFlags(); err != nil {
+		return err
+	}
 	if *verbose {
 		fmt.Printf("Parsing packages: %v\n", patterns)
 	}
@@ -89,18 +150,140 @@ func run(patterns []string) error {
 	opts.PackageName = *protoPackage
 	opts.GoPackage = *goPackage
 	opts.IncludePrivate = *includePrivate
+	opts.RenameZeroEnumValue = *renameEnumZero
+	opts.DropEnumAliases = *dropAliases
+	opts.FlagStyle = transformer.FlagStyle(*flagStyle)
+	opts.MessageNaming = transformer.MessageNaming(*messageNaming)
+	opts.Ordering = transformer.Ordering(*ordering)
+	opts.Syntax = transformer.Syntax(*syntax)
+	opts.Edition = *edition
+	opts.WrapRPCMessages = *wrapRPC
+	opts.ErrorsAsStatus = *errorStatus
+	opts.ManagedOptions = *managed
+	opts.FileOptions = fileOptions
+	opts.SkipFuncOptions = *funcOptions
+	opts.MergeImportCycles = *mergeCycles
+	for _, typ := range strings.Split(*ignoreParams, ",") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			opts.IgnoredParamTypes = append(opts.IgnoredParamTypes, typ)
+		}
+	}
 
-	gen := generator.NewGenerator()
+	genOpts := generator.DefaultOptions()
+	genOpts.CommentWidth = *commentWidth
+	genOpts.Align = *align
+	gen := generator.NewGeneratorWithOptions(genOpts)
 	trans := transformer.NewTransformer(opts)
 
+	var files []descriptor.File
 	if *oneFile {
-		return generateSingleFile(pkgs, trans, gen)
+		files, err = generateSingleFile(pkgs, trans, gen)
+	} else {
+		files, err = generatePerPackage(pkgs, trans, gen)
+	}
+	if err != nil {
+		return err
+	}
+	if *bufConfig {
+		if err := writeBufConfig(files); err != nil {
+			return err
+		}
+	}
+	if *descriptorSetOut == "" {
+		return nil
+	}
+	return writeDescriptorSet(files)
+}
+
+// checkFlags rejects flag values outside the choices a flag offers.
+func checkFlags() error {
+	return errors.Join(
+		oneOf("order", *ordering, transformer.OrderingSource, transformer.OrderingAlphabetical, transformer.OrderingTopological),
+		oneOf("flags-style", *flagStyle, transformer.FlagStyleEnum, transformer.FlagStyleComment),
+		oneOf("message-naming", *messageNaming, transformer.MessageNamingService, transformer.MessageNamingSuffix),
+		oneOf("syntax", *syntax, transformer.SyntaxProto2, transformer.SyntaxProto3, transformer.SyntaxEditions),
+		oneOf("layout", *layout, transformer.LayoutFlat, transformer.LayoutPackage, transformer.LayoutFile),
+	)
+}
+
+func oneOf[T ~string](name, value string, valid ...T) error {
+	if slices.Contains(valid, T(value)) {
+		return nil
+	}
+	choices := ct.Map(valid, func(v T) string { return string(v) })
+	return fmt.Errorf("invalid -%s %q: must be one of %s", name, value, strings.Join(choices, ", "))
+}
+
+// writeBufConfig writes or updates buf.yaml in -buf-dir, declaring -out as
+// a module with the deps its imports need, and writes a starter
+// buf.gen.yaml if there is none.
+func writeBufConfig(files []descriptor.File) error {
+	modulePath, err := filepath.Rel(*bufDir, *outDir)
+	if err != nil {
+		return fmt.Errorf("failed to locate %s from %s: %w", *outDir, *bufDir, err)
+	}
+	var imports, generated []string
+	for _, f := range files {
+		imports = append(imports, f.Proto.Imports...)
+		generated = append(generated, f.Name)
+	}
+	deps, unknown := buf.Deps(imports, generated)
+	for _, imp := range unknown {
+		reportDiagnostics([]transformer.Diagnostic{{
+			Severity: transformer.SeverityWarning, Element: "buf.yaml",
+			Message: fmt.Sprintf("no known dependency provides %s; add it to deps", imp),
+		}})
+	}
+
+	configPath := filepath.Join(*bufDir, "buf.yaml")
+	existing, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+	config, err := buf.Config(string(existing), filepath.ToSlash(modulePath), deps)
+	if err != nil {
+		reportDiagnostics([]transformer.Diagnostic{{
+			Severity: transformer.SeverityWarning, Element: configPath,
+			Message: fmt.Sprintf("left unchanged: %v; add the module and deps by hand", err),
+		}})
+	} else {
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", configPath, err)
+		}
+		fmt.Println(configPath)
+	}
+
+	genPath := filepath.Join(*bufDir, "buf.gen.yaml")
+	if _, err := os.Stat(genPath); err == nil {
+		return nil
+	}
+	gateway := slices.Contains(imports, "google/api/annotations.proto")
+	if err := os.WriteFile(genPath, []byte(buf.GenConfig(gateway)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", genPath, err)
+	}
+	fmt.Println(genPath)
+	return nil
+}
+
+// writeDescriptorSet encodes the generated files, with the well-known
+// files they import, as a FileDescriptorSet.
+func writeDescriptorSet(files []descriptor.File) error {
+	data, diags := descriptor.Marshal(files)
+	reportDiagnostics(diags)
+	if err := os.WriteFile(*descriptorSetOut, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *descriptorSetOut, err)
+	}
+	if *verbose {
+		fmt.Printf("Generated: %s (%d files)\n", *descriptorSetOut, len(files))
+	} else {
+		fmt.Println(*descriptorSetOut)
 	}
-	return generatePerPackage(pkgs, trans, gen)
+	return nil
 }
 
-func generateSingleFile(pkgs []parser.GoPackage, trans *transformer.Transformer, gen *generator.Generator) error {
+func generateSingleFile(pkgs []parser.GoPackage, trans *transformer.Transformer, gen *generator.Generator) ([]descriptor.File, error) {
 	proto := trans.Transform(pkgs)
+	reportDiagnostics(proto.Diagnostics)
 	content := gen.Generate(proto)
 
 	filename := *fileName
@@ -114,7 +297,7 @@ func generateSingleFile(pkgs []parser.GoPackage, trans *transformer.Transformer,
 
 	outPath := filepath.Join(*outDir, filename)
 	if err := os.WriteFile(outPath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("failed to write %s: %w", outPath, err)
+		return nil, fmt.Errorf("failed to write %s: %w", outPath, err)
 	}
 
 	if *verbose {
@@ -122,10 +305,11 @@ func generateSingleFile(pkgs []parser.GoPackage, trans *transformer.Transformer,
 	} else {
 		fmt.Println(outPath)
 	}
-	return nil
+	return []descriptor.File{{Name: filename, Proto: proto, Source: content}}, nil
 }
 
-func generatePerPackage(pkgs []parser.GoPackage, trans *transformer.Transformer, gen *generator.Generator) error {
+func generatePerPackage(pkgs []parser.GoPackage, trans *transformer.Transformer, gen *generator.Generator) ([]descriptor.File, error) {
+	var generated []parser.GoPackage
 	for _, pkg := range pkgs {
 		if len(pkg.Structs) == 0 && len(pkg.Interfaces) == 0 {
 			if *verbose {
@@ -133,29 +317,93 @@ func generatePerPackage(pkgs []parser.GoPackage, trans *transformer.Transformer,
 			}
 			continue
 		}
+		generated = append(generated, pkg)
+	}
 
-		proto := trans.Transform([]parser.GoPackage{pkg})
-		if len(proto.Messages) == 0 && len(proto.Services) == 0 && len(proto.Enums) == 0 {
-			if *verbose {
-				fmt.Printf("Skipping package with no proto types: %s\n", pkg.Path)
+	// Every package is placed before any is transformed, so references
+	// between them resolve to the files they are written to.
+	var placed [][]transformer.PackageFile
+	var all []transformer.PackageFile
+	owners := make(map[string]string)
+	for _, pkg := range generated {
+		pkgFiles, diags, err := trans.Files(pkg, transformer.Layout(*layout))
+		reportDiagnostics(diags)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range pkgFiles {
+			if owner, ok := owners[f.Name]; ok && owner != pkg.Path {
+				return nil, fmt.Errorf("packages %s and %s would both be written to %s; %s", owner, pkg.Path, f.Name, collisionHint())
 			}
-			continue
+			owners[f.Name] = pkg.Path
 		}
+		placed = append(placed, pkgFiles)
+		all = append(all, pkgFiles...)
+	}
+	trans.Link(all)
 
-		content := gen.Generate(proto)
-		filename := pkg.Name + ".proto"
-		outPath := filepath.Join(*outDir, filename)
-
-		if err := os.WriteFile(outPath, []byte(content), 0644); err != nil {
-			return fmt.Errorf("failed to write %s: %w", outPath, err)
+	var files []descriptor.File
+	for _, pkgFiles := range placed {
+		for i, proto := range trans.TransformFiles(pkgFiles) {
+			file, err := writeFile(pkgFiles[i].Name, proto, gen)
+			if err != nil {
+				return nil, err
+			}
+			if file != nil {
+				files = append(files, *file)
+			}
 		}
+	}
+	return files, nil
+}
+
+// collisionHint suggests how to give two packages distinct paths under
+// the current layout.
+func collisionHint() string {
+	switch transformer.Layout(*layout) {
+	case transformer.LayoutPackage:
+		return "give them distinct proto packages, e.g. by dropping -package"
+	case transformer.LayoutFile:
+		return "give them distinct proto packages, e.g. by dropping -package, or rename one of the Go files"
+	}
+	return "use -layout=package or rename one"
+}
 
+// writeFile generates proto into filename under -out. A file that
+// declares nothing is skipped and nil is returned.
+func writeFile(filename string, proto transformer.Proto, gen *generator.Generator) (*descriptor.File, error) {
+	if len(proto.Messages) == 0 && len(proto.Services) == 0 && len(proto.Enums) == 0 {
 		if *verbose {
-			fmt.Printf("Generated: %s (%d messages, %d services, %d enums)\n",
-				outPath, len(proto.Messages), len(proto.Services), len(proto.Enums))
-		} else {
-			fmt.Println(outPath)
+			fmt.Printf("Skipping %s with no proto types\n", filename)
 		}
+		return nil, nil
+	}
+
+	reportDiagnostics(proto.Diagnostics)
+	content := gen.Generate(proto)
+	outPath := filepath.Join(*outDir, filepath.FromSlash(filename))
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(outPath), err)
+	}
+	if err := os.WriteFile(outPath, []byte(content), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", outPath, err)
+	}
+
+	if *verbose {
+		fmt.Printf("Generated: %s (%d messages, %d services, %d enums)\n",
+			outPath, len(proto.Messages), len(proto.Services), len(proto.Enums))
+	} else {
+		fmt.Println(outPath)
+	}
+	return &descriptor.File{Name: filename, Proto: proto, Source: content}, nil
+}
+
+func reportDiagnostics(diags []transformer.Diagnostic) {
+	for _, d := range diags {
+		if d.Severity == transformer.SeverityInfo && !*verbose {
+			continue
+		}
+		fmt.Fprintln(os.Stderr, d)
 	}
-	return nil
 }
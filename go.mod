@@ -2,7 +2,10 @@ module github.com/vinodhalaharvi/go2proto
 
 go 1.25
 
-require golang.org/x/tools v0.40.0
+require (
+	golang.org/x/tools v0.40.0
+	google.golang.org/protobuf v1.36.9
+)
 
 require (
 	golang.org/x/mod v0.31.0 // indirect
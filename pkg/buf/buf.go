@@ -0,0 +1,286 @@
+// Package buf writes buf module configuration for generated .proto files.
+package buf
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/ct"
+)
+
+// Dependencies of well-known import prefixes. google/protobuf files are
+// built into buf and need none.
+var importDeps = []struct{ prefix, dep string }{
+	{"google/protobuf/", ""},
+	{"google/api/", "buf.build/googleapis/googleapis"},
+	{"google/rpc/", "buf.build/googleapis/googleapis"},
+	{"google/type/", "buf.build/googleapis/googleapis"},
+	{"google/longrunning/", "buf.build/googleapis/googleapis"},
+	{"buf/validate/", "buf.build/bufbuild/protovalidate"},
+	{"grpc/", "buf.build/grpc/grpc"},
+}
+
+// Deps returns the buf dependencies that provide imports, sorted. Imports
+// of generated files are local to the module. Imports no known
+// dependency provides are returned as unknown.
+func Deps(imports, generated []string) (deps, unknown []string) {
+	local := make(map[string]bool, len(generated))
+	for _, name := range generated {
+		local[name] = true
+	}
+	for _, imp := range ct.Unique(imports) {
+		if local[imp] {
+			continue
+		}
+		dep, ok := depFor(imp)
+		switch {
+		case !ok:
+			unknown = append(unknown, imp)
+		case dep != "":
+			deps = append(deps, dep)
+		}
+	}
+	deps = ct.Unique(deps)
+	sort.Strings(deps)
+	sort.Strings(unknown)
+	return deps, unknown
+}
+
+func depFor(imp string) (string, bool) {
+	for _, d := range importDeps {
+		if strings.HasPrefix(imp, d.prefix) {
+			return d.dep, true
+		}
+	}
+	return "", false
+}
+
+// Config renders a buf.yaml declaring the module at modulePath with deps
+// and the standard lint and breaking rules. When existing holds a
+// buf.yaml, it is updated instead: the module and any missing deps are
+// added and everything else is kept as written. Lists written in block
+// style or on one line in flow style, [a, b], can be updated; others are
+// an error.
+func Config(existing, modulePath string, deps []string) (string, error) {
+	modulePath = path.Clean(modulePath)
+	if strings.TrimSpace(existing) == "" {
+		var sb strings.Builder
+		sb.WriteString("version: v2\n")
+		fmt.Fprintf(&sb, "modules:\n  - path: %s\n", modulePath)
+		if len(deps) > 0 {
+			sb.WriteString("deps:\n")
+			for _, dep := range deps {
+				fmt.Fprintf(&sb, "  - %s\n", dep)
+			}
+		}
+		sb.WriteString("lint:\n  use:\n    - STANDARD\n")
+		sb.WriteString("breaking:\n  use:\n    - FILE\n")
+		return sb.String(), nil
+	}
+
+	lines := strings.Split(strings.TrimRight(existing, "\n"), "\n")
+	// A v1 buf.yaml sits in the module itself and has no modules list.
+	if !v1Config.MatchString(existing) {
+		has, err := hasModule(lines, modulePath)
+		if err != nil {
+			return "", err
+		}
+		if !has {
+			if lines, err = addToSection(lines, "modules", []string{"path: " + modulePath}); err != nil {
+				return "", err
+			}
+		}
+	}
+	lines, err := addToSection(lines, "deps", deps)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+var v1Config = regexp.MustCompile(`(?m)^version:\s*v1(beta1)?\s*$`)
+
+// hasModule reports whether the modules section lists a module at
+// modulePath, in whichever key order and spelling, e.g. ./proto.
+func hasModule(lines []string, modulePath string) (bool, error) {
+	start, end, inline := section(lines, "modules")
+	if start < 0 {
+		return false, nil
+	}
+	entries := lines[start+1 : end]
+	if inline != "" {
+		items, ok := flowSequence(inline)
+		if !ok {
+			return false, fmt.Errorf("cannot update modules: %s", inline)
+		}
+		entries = nil
+		for _, item := range items {
+			entries = append(entries, strings.Split(strings.Trim(item, "{}"), ",")...)
+		}
+	}
+	for _, entry := range entries {
+		entry = strings.TrimPrefix(strings.TrimSpace(entry), "- ")
+		if value, ok := strings.CutPrefix(entry, "path:"); ok && path.Clean(unquote(value)) == modulePath {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func unquote(value string) string {
+	return strings.Trim(strings.TrimSpace(value), `"'`)
+}
+
+// section returns the line of a top-level YAML key and the end of its
+// block, past any trailing blank lines, or -1 if there is none. inline is
+// the value written on the key's line, if any.
+func section(lines []string, key string) (start, end int, inline string) {
+	start = -1
+	for i, line := range lines {
+		rest, ok := strings.CutPrefix(line, key+":")
+		if !ok || (rest != "" && rest[0] != ' ') {
+			continue
+		}
+		start = i
+		if rest = strings.TrimSpace(rest); !strings.HasPrefix(rest, "#") {
+			inline = rest
+		}
+		break
+	}
+	if start < 0 {
+		return -1, -1, ""
+	}
+	end = start + 1
+	for ; end < len(lines); end++ {
+		line := lines[end]
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-") {
+			break
+		}
+	}
+	for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return start, end, inline
+}
+
+// flowSequence splits a one-line flow sequence, [a, {b: c}], into its
+// items. It reports false for anything else, such as a sequence that
+// continues on the next lines.
+func flowSequence(value string) ([]string, bool) {
+	inner, ok := strings.CutPrefix(value, "[")
+	if !ok {
+		return nil, false
+	}
+	if inner, ok = strings.CutSuffix(inner, "]"); !ok {
+		return nil, false
+	}
+	var items []string
+	depth, from := 0, 0
+	for i, r := range inner {
+		switch r {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(inner[from:i]))
+				from = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(inner[from:]); last != "" {
+		items = append(items, last)
+	}
+	return items, depth == 0
+}
+
+// addToSection appends the list items missing from a top-level YAML
+// section, indented like the items already there, creating the section
+// at the end if there is none. Items are written in block style, as
+// "path: proto" for a mapping; a one-line flow sequence stays on one
+// line.
+func addToSection(lines []string, key string, items []string) ([]string, error) {
+	start, end, inline := section(lines, key)
+	if inline != "" {
+		return addToFlowSequence(lines, start, key, inline, items)
+	}
+	if start < 0 {
+		if len(items) == 0 {
+			return lines, nil
+		}
+		lines = append(lines, key+":")
+		start, end = len(lines)-1, len(lines)
+	}
+
+	indent := ""
+	present := make(map[string]bool)
+	for _, line := range lines[start+1 : end] {
+		trimmed := strings.TrimLeft(line, " ")
+		item, ok := strings.CutPrefix(trimmed, "- ")
+		if !ok {
+			continue
+		}
+		if len(present) == 0 {
+			indent = line[:len(line)-len(trimmed)]
+		}
+		present[unquote(item)] = true
+	}
+	if len(present) == 0 {
+		indent = "  "
+	}
+
+	var added []string
+	for _, item := range items {
+		if !present[item] {
+			added = append(added, indent+"- "+item)
+		}
+	}
+	return append(lines[:end:end], append(added, lines[end:]...)...), nil
+}
+
+// addToFlowSequence adds the missing items to the flow sequence written
+// on line start.
+func addToFlowSequence(lines []string, start int, key, inline string, items []string) ([]string, error) {
+	existing, ok := flowSequence(inline)
+	if !ok {
+		return nil, fmt.Errorf("cannot update %s: %s", key, inline)
+	}
+	present := make(map[string]bool)
+	for _, item := range existing {
+		present[unquote(item)] = true
+	}
+	all := existing
+	for _, item := range items {
+		if present[item] {
+			continue
+		}
+		if strings.Contains(item, ": ") {
+			item = "{" + item + "}"
+		}
+		all = append(all, item)
+	}
+	if len(all) == len(existing) {
+		return lines, nil
+	}
+	lines[start] = key + ": [" + strings.Join(all, ", ") + "]"
+	return lines, nil
+}
+
+// GenConfig renders a starter buf.gen.yaml generating Go messages and gRPC
+// stubs into gen/go, plus grpc-gateway handlers when gateway is set.
+func GenConfig(gateway bool) string {
+	plugins := []string{"buf.build/protocolbuffers/go", "buf.build/grpc/go"}
+	if gateway {
+		plugins = append(plugins, "buf.build/grpc-ecosystem/gateway")
+	}
+	var sb strings.Builder
+	sb.WriteString("version: v2\nplugins:\n")
+	for _, plugin := range plugins {
+		fmt.Fprintf(&sb, "  - remote: %s\n    out: gen/go\n    opt: paths=source_relative\n", plugin)
+	}
+	return sb.String()
+}
@@ -0,0 +1,461 @@
+// Package descriptor encodes Proto definitions as a binary
+// google.protobuf.FileDescriptorSet, without running a compiler.
+package descriptor
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/transformer"
+)
+
+// File is a generated .proto file.
+type File struct {
+	Name  string // Import path relative to the output root, e.g. users.proto
+	Proto transformer.Proto
+	// Source is the rendered .proto text. When set, the descriptor
+	// carries source code info: spans and comments.
+	Source string
+}
+
+// entry is a file of the set, generated or well-known.
+type entry struct {
+	name       string
+	proto      transformer.Proto
+	extensions []extension
+	source     string
+}
+
+type symbolKind int
+
+const (
+	symbolMessage symbolKind = iota + 1
+	symbolEnum
+)
+
+// builder encodes the files of one set. It resolves type references
+// against every file in the set and collects diagnostics.
+type builder struct {
+	symbols         map[string]symbolKind // fully qualified names without the leading dot
+	extensionRanges map[string][2]int     // of well-known messages, by fully qualified name
+	diags           []transformer.Diagnostic
+}
+
+// Marshal encodes files as a FileDescriptorSet. The well-known files they
+// import are included, dependencies first, so the set is self-contained;
+// of google/protobuf/descriptor.proto only MethodOptions is described.
+// Imports go2proto cannot describe, and options it cannot encode, are
+// reported as diagnostics.
+func Marshal(files []File) ([]byte, []transformer.Diagnostic) {
+	b := &builder{symbols: make(map[string]symbolKind), extensionRanges: make(map[string][2]int)}
+	entries := make(map[string]entry)
+	for _, f := range files {
+		entries[f.Name] = entry{name: f.Name, proto: f.Proto, source: f.Source}
+	}
+
+	var ordered []entry
+	visited := make(map[string]bool)
+	var visit func(name, importer string)
+	visit = func(name, importer string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		e, ok := entries[name]
+		if !ok {
+			wk, known := wellKnownFiles[name]
+			if !known {
+				b.warn("file "+importer, fmt.Sprintf("import %s is not included in the descriptor set", name))
+				return
+			}
+			e = entry{name: name, proto: wk.proto, extensions: wk.extensions}
+			maps.Copy(b.extensionRanges, wk.extensionRanges)
+		}
+		for _, dep := range sortedImports(e.proto) {
+			visit(dep, name)
+		}
+		ordered = append(ordered, e)
+	}
+	for _, f := range files {
+		visit(f.Name, "")
+	}
+
+	for _, e := range ordered {
+		b.declare(e.proto.Package, e.proto.Messages, e.proto.Enums)
+	}
+	var set encoder
+	for _, e := range ordered {
+		set.message(1, func(enc *encoder) { b.file(enc, e) })
+	}
+	return set.b, b.diags
+}
+
+func (b *builder) warn(element, message string) {
+	b.diags = append(b.diags, transformer.Diagnostic{Severity: transformer.SeverityWarning, Element: element, Message: message})
+}
+
+// declare records the messages and enums of a scope.
+func (b *builder) declare(scope string, messages []transformer.ProtoMessage, enums []transformer.ProtoEnum) {
+	for _, e := range enums {
+		b.symbols[qualify(scope, e.Name)] = symbolEnum
+	}
+	for _, m := range messages {
+		full := qualify(scope, m.Name)
+		b.symbols[full] = symbolMessage
+		for _, f := range m.Fields {
+			if f.MapKey != "" {
+				b.symbols[qualify(full, camelCase(f.Name)+"Entry")] = symbolMessage
+			}
+		}
+		b.declare(full, m.Nested, m.Enums)
+	}
+}
+
+// resolve finds the declaration a type name refers to from scope,
+// searching enclosing scopes outwards as protoc does.
+func (b *builder) resolve(scope, name string) (string, symbolKind, bool) {
+	if full, ok := strings.CutPrefix(name, "."); ok {
+		kind, found := b.symbols[full]
+		return "." + full, kind, found
+	}
+	for {
+		candidate := qualify(scope, name)
+		if kind, ok := b.symbols[candidate]; ok {
+			return "." + candidate, kind, true
+		}
+		if scope == "" {
+			return "." + name, symbolMessage, false
+		}
+		scope = parentScope(scope)
+	}
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func parentScope(scope string) string {
+	if i := strings.LastIndex(scope, "."); i >= 0 {
+		return scope[:i]
+	}
+	return ""
+}
+
+// sortedImports returns the imports in the order the generator writes
+// them, which is also the order of the dependency list.
+func sortedImports(p transformer.Proto) []string {
+	imports := slices.Clone(p.Imports)
+	sort.Strings(imports)
+	return imports
+}
+
+// FileDescriptorProto.
+func (b *builder) file(e *encoder, f entry) {
+	p := f.proto
+	element := "file " + f.name
+	e.string(1, f.name)
+	e.optString(2, p.Package)
+	for _, dep := range sortedImports(p) {
+		e.string(3, dep)
+	}
+	for _, m := range p.Messages {
+		e.message(4, func(enc *encoder) { b.messageType(enc, m, p.Package, p) })
+	}
+	for _, en := range p.Enums {
+		e.message(5, func(enc *encoder) { b.enumType(enc, en, p.Package) })
+	}
+	for _, s := range p.Services {
+		e.message(6, func(enc *encoder) { b.service(enc, s, p.Package) })
+	}
+	for _, ext := range f.extensions {
+		e.message(7, func(enc *encoder) {
+			enc.string(1, ext.name)
+			enc.string(2, ext.extendee)
+			enc.int(3, int64(ext.number))
+			enc.int(4, labelOptional)
+			enc.int(5, typeMessage)
+			enc.string(6, ext.typeName)
+			enc.string(10, jsonName(ext.name))
+		})
+	}
+
+	names := make([]string, 0, len(p.Options))
+	for name := range p.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	options := make([]transformer.ProtoOption, len(names))
+	for i, name := range names {
+		options[i] = transformer.ProtoOption{Name: name, Value: p.Options[name]}
+	}
+	if opts := b.options(element, fileOptions, 50, options); opts != nil {
+		e.bytes(8, opts)
+	}
+
+	if f.source != "" {
+		e.message(9, func(enc *encoder) { sourceInfo(enc, f) })
+	}
+	switch {
+	case p.Edition != "":
+		e.string(12, "editions")
+		e.int(14, editionNumber(p.Edition))
+	case p.Syntax == "" || p.Syntax == "proto3":
+		e.string(12, "proto3")
+	}
+}
+
+// DescriptorProto.
+func (b *builder) messageType(e *encoder, m transformer.ProtoMessage, scope string, file transformer.Proto) {
+	full := qualify(scope, m.Name)
+	e.string(1, m.Name)
+
+	oneofs := make(map[string]int)
+	for i, o := range m.Oneofs {
+		oneofs[o.Name] = i
+	}
+	var synthetic []string
+	var entries []transformer.ProtoMessage
+	for _, f := range m.Fields {
+		oneof, proto3Optional := -1, false
+		if i, ok := oneofs[f.Oneof]; ok && f.Oneof != "" {
+			oneof = i
+		} else if f.Optional && file.Edition == "" && file.Syntax != "proto2" {
+			// proto3 optional fields live in a synthetic oneof.
+			oneof, proto3Optional = len(m.Oneofs)+len(synthetic), true
+			synthetic = append(synthetic, "_"+f.Name)
+		}
+		if f.MapKey != "" {
+			entry := transformer.ProtoMessage{Name: camelCase(f.Name) + "Entry", Fields: []transformer.ProtoField{
+				{Name: "key", Type: f.MapKey, Number: 1},
+				{Name: "value", Type: f.MapValue, Number: 2},
+			}}
+			entries = append(entries, entry)
+			f.Type, f.Repeated = entry.Name, true
+		}
+		e.message(2, func(enc *encoder) { b.field(enc, f, full, oneof, proto3Optional) })
+	}
+	for _, nested := range m.Nested {
+		e.message(3, func(enc *encoder) { b.messageType(enc, nested, full, file) })
+	}
+	for _, entry := range entries {
+		e.message(3, func(enc *encoder) {
+			enc.string(1, entry.Name)
+			for _, f := range entry.Fields {
+				enc.message(2, func(fe *encoder) { b.field(fe, f, full, -1, false) })
+			}
+			enc.message(7, func(oe *encoder) { oe.bool(7, true) }) // map_entry
+		})
+	}
+	for _, en := range m.Enums {
+		e.message(4, func(enc *encoder) { b.enumType(enc, en, full) })
+	}
+	if r, ok := b.extensionRanges[full]; ok {
+		e.message(5, func(enc *encoder) {
+			enc.int(1, int64(r[0]))
+			enc.int(2, int64(r[1]))
+		})
+	}
+	if opts := b.options("message "+full, messageOptions, 12, transformer.WithDeprecated(m.Deprecated, m.Options)); opts != nil {
+		e.bytes(7, opts)
+	}
+	for _, o := range m.Oneofs {
+		e.message(8, func(enc *encoder) { enc.string(1, o.Name) })
+	}
+	for _, name := range synthetic {
+		e.message(8, func(enc *encoder) { enc.string(1, name) })
+	}
+}
+
+// Field labels and types of FieldDescriptorProto.
+const (
+	labelOptional = 1
+	labelRequired = 2
+	labelRepeated = 3
+
+	typeMessage = 11
+	typeEnum    = 14
+)
+
+var scalarTypes = map[string]int64{
+	"double": 1, "float": 2, "int64": 3, "uint64": 4, "int32": 5, "fixed64": 6,
+	"fixed32": 7, "bool": 8, "string": 9, "bytes": 12, "uint32": 13,
+	"sfixed32": 15, "sfixed64": 16, "sint32": 17, "sint64": 18,
+}
+
+// FieldDescriptorProto.
+func (b *builder) field(e *encoder, f transformer.ProtoField, scope string, oneof int, proto3Optional bool) {
+	element := fmt.Sprintf("field %s.%s", scope, f.Name)
+	e.string(1, f.Name)
+	e.int(3, int64(f.Number))
+	switch {
+	case f.Repeated:
+		e.int(4, labelRepeated)
+	case f.Required:
+		e.int(4, labelRequired)
+	default:
+		e.int(4, labelOptional)
+	}
+	if t, ok := scalarTypes[f.Type]; ok {
+		e.int(5, t)
+	} else {
+		full, kind, ok := b.resolve(scope, f.Type)
+		if !ok {
+			b.warn(element, fmt.Sprintf("type %s is not defined in the descriptor set", f.Type))
+		}
+		if kind == symbolEnum {
+			e.int(5, typeEnum)
+		} else {
+			e.int(5, typeMessage)
+		}
+		e.string(6, full)
+	}
+	if f.Default != nil {
+		e.string(7, f.Default.Value)
+	}
+
+	json := jsonName(f.Name)
+	var options []transformer.ProtoOption
+	for _, o := range f.Options {
+		if o.Name == "json_name" {
+			json = o.Value.Value
+			continue
+		}
+		options = append(options, o)
+	}
+	if opts := b.options(element, fieldOptions, 21, transformer.WithDeprecated(f.Deprecated, options)); opts != nil {
+		e.bytes(8, opts)
+	}
+	if oneof >= 0 {
+		e.int(9, int64(oneof))
+	}
+	e.string(10, json)
+	if proto3Optional {
+		e.bool(17, true)
+	}
+}
+
+// EnumDescriptorProto.
+func (b *builder) enumType(e *encoder, en transformer.ProtoEnum, scope string) {
+	full := qualify(scope, en.Name)
+	e.string(1, en.Name)
+	for _, v := range en.Values {
+		e.message(2, func(enc *encoder) {
+			enc.string(1, v.Name)
+			enc.int(2, int64(v.Number))
+			if opts := b.options("enum value "+qualify(scope, v.Name), enumValueOptions, 2, transformer.WithDeprecated(v.Deprecated, v.Options)); opts != nil {
+				enc.bytes(3, opts)
+			}
+		})
+	}
+	options := transformer.WithDeprecated(en.Deprecated, en.Options)
+	if en.AllowAlias {
+		options = append([]transformer.ProtoOption{{Name: "allow_alias", Value: transformer.BoolOption(true)}}, options...)
+	}
+	if opts := b.options("enum "+full, enumOptions, 7, options); opts != nil {
+		e.bytes(3, opts)
+	}
+}
+
+// ServiceDescriptorProto.
+func (b *builder) service(e *encoder, s transformer.ProtoService, scope string) {
+	full := qualify(scope, s.Name)
+	e.string(1, s.Name)
+	for _, r := range s.Methods {
+		e.message(2, func(enc *encoder) { b.method(enc, r, full, scope) })
+	}
+	if opts := b.options("service "+full, serviceOptions, 34, transformer.WithDeprecated(s.Deprecated, s.Options)); opts != nil {
+		e.bytes(3, opts)
+	}
+}
+
+// MethodDescriptorProto.
+func (b *builder) method(e *encoder, r transformer.ProtoRPC, service, scope string) {
+	element := fmt.Sprintf("rpc %s.%s", service, r.Name)
+	e.string(1, r.Name)
+	input, _, ok := b.resolve(scope, r.InputType)
+	if !ok {
+		b.warn(element, fmt.Sprintf("type %s is not defined in the descriptor set", r.InputType))
+	}
+	output, _, ok := b.resolve(scope, r.OutputType)
+	if !ok {
+		b.warn(element, fmt.Sprintf("type %s is not defined in the descriptor set", r.OutputType))
+	}
+	e.string(2, input)
+	e.string(3, output)
+
+	options := transformer.WithDeprecated(r.Deprecated, r.Options)
+	if r.IdempotencyLevel != "" {
+		options = append(options, transformer.ProtoOption{Name: "idempotency_level", Value: transformer.IdentOption(r.IdempotencyLevel)})
+	}
+	opts := b.options(element, methodOptions, 35, options)
+	if r.HTTP != nil {
+		var enc encoder
+		enc.b = opts
+		enc.message(httpExtension, func(he *encoder) {
+			he.string(httpMethods[r.HTTP.Method], r.HTTP.Path)
+			he.optString(7, r.HTTP.Body)
+			he.optString(12, r.HTTP.ResponseBody)
+		})
+		opts = enc.b
+	}
+	if opts != nil {
+		e.bytes(4, opts)
+	}
+	if r.ClientStreaming {
+		e.bool(5, true)
+	}
+	if r.ServerStreaming {
+		e.bool(6, true)
+	}
+}
+
+// editionNumber maps an edition name to the Edition enum.
+func editionNumber(edition string) int64 {
+	switch edition {
+	case "2023":
+		return 1000
+	case "2024":
+		return 1001
+	}
+	n, err := strconv.ParseInt(edition, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// jsonName computes the default JSON name of a field the way protoc does:
+// underscores are dropped and the letter after each is upper-cased.
+func jsonName(name string) string {
+	var sb strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper && 'a' <= r && r <= 'z':
+			sb.WriteRune(r - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteRune(r)
+			upper = false
+		}
+	}
+	return sb.String()
+}
+
+// camelCase names the entry message of a map field: labels gives Labels.
+func camelCase(name string) string {
+	json := jsonName(name)
+	if json == "" {
+		return json
+	}
+	return strings.ToUpper(json[:1]) + json[1:]
+}
@@ -0,0 +1,300 @@
+package descriptor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/generator"
+	"github.com/vinodhalaharvi/go2proto/pkg/transformer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// wireField is one decoded field of a protobuf message.
+type wireField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// decode splits a message into its fields. Only the varint and
+// length-delimited wire types the encoder writes are supported.
+func decode(t *testing.T, b []byte) []wireField {
+	t.Helper()
+	var fields []wireField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad tag in %x", b)
+		}
+		b = b[n:]
+		f := wireField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.varint, n = binary.Uvarint(b)
+			b = b[n:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || int(size) > len(b[n:]) {
+				t.Fatalf("bad length in %x", b)
+			}
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// get returns the fields numbered num.
+func get(fields []wireField, num int) []wireField {
+	var out []wireField
+	for _, f := range fields {
+		if f.num == num {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func getString(t *testing.T, fields []wireField, num int) string {
+	t.Helper()
+	matches := get(fields, num)
+	if len(matches) != 1 {
+		t.Fatalf("field %d occurs %d times, want 1", num, len(matches))
+	}
+	return string(matches[0].bytes)
+}
+
+func getInt(t *testing.T, fields []wireField, num int) uint64 {
+	t.Helper()
+	matches := get(fields, num)
+	if len(matches) != 1 {
+		t.Fatalf("field %d occurs %d times, want 1", num, len(matches))
+	}
+	return matches[0].varint
+}
+
+// packed decodes a packed repeated varint field.
+func packed(t *testing.T, s string) []int {
+	t.Helper()
+	b := []byte(s)
+	var out []int
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad packed varint in %x", b)
+		}
+		out = append(out, int(v))
+		b = b[n:]
+	}
+	return out
+}
+
+var testProto = transformer.Proto{
+	Syntax:  "proto3",
+	Package: "acme.v1",
+	Enums: []transformer.ProtoEnum{{
+		Name: "Status",
+		Values: []transformer.ProtoEnumValue{
+			{Name: "STATUS_UNSPECIFIED", Number: 0},
+			{Name: "STATUS_ACTIVE", Number: 1},
+		},
+	}},
+	Messages: []transformer.ProtoMessage{{
+		Name: "User",
+		Fields: []transformer.ProtoField{
+			{Name: "id", Type: "string", Number: 1, Comments: []string{"The user ID."}},
+			{Name: "status", Type: "Status", Number: 2},
+			{Name: "tags", Type: "string", Number: 3, Repeated: true},
+		},
+	}},
+}
+
+// marshalFile encodes p rendered with opts and returns its decoded
+// FileDescriptorProto and source.
+func marshalFile(t *testing.T, opts generator.Options) ([]wireField, string) {
+	t.Helper()
+	source := generator.NewGeneratorWithOptions(opts).Generate(testProto)
+	data, diags := Marshal([]File{{Name: "acme/v1/users.proto", Proto: testProto, Source: source}})
+	if len(diags) > 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	files := get(decode(t, data), 1)
+	if len(files) != 1 {
+		t.Fatalf("set has %d files, want 1", len(files))
+	}
+	return decode(t, files[0].bytes), source
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	file, source := marshalFile(t, generator.DefaultOptions())
+
+	if got := getString(t, file, 1); got != "acme/v1/users.proto" {
+		t.Errorf("name = %q", got)
+	}
+	if got := getString(t, file, 2); got != "acme.v1" {
+		t.Errorf("package = %q", got)
+	}
+	if got := getString(t, file, 12); got != "proto3" {
+		t.Errorf("syntax = %q", got)
+	}
+
+	messages := get(file, 4)
+	if len(messages) != 1 {
+		t.Fatalf("%d messages, want 1", len(messages))
+	}
+	user := decode(t, messages[0].bytes)
+	if got := getString(t, user, 1); got != "User" {
+		t.Errorf("message name = %q", got)
+	}
+	want := []struct {
+		name       string
+		number     uint64
+		label, typ uint64
+		typeName   string
+	}{
+		{"id", 1, labelOptional, 9, ""},
+		{"status", 2, labelOptional, typeEnum, ".acme.v1.Status"},
+		{"tags", 3, labelRepeated, 9, ""},
+	}
+	fields := get(user, 2)
+	if len(fields) != len(want) {
+		t.Fatalf("%d fields, want %d", len(fields), len(want))
+	}
+	for i, w := range want {
+		f := decode(t, fields[i].bytes)
+		if got := getString(t, f, 1); got != w.name {
+			t.Errorf("field %d name = %q, want %q", i, got, w.name)
+		}
+		if got := getInt(t, f, 3); got != w.number {
+			t.Errorf("field %s number = %d, want %d", w.name, got, w.number)
+		}
+		if got := getInt(t, f, 4); got != w.label {
+			t.Errorf("field %s label = %d, want %d", w.name, got, w.label)
+		}
+		if got := getInt(t, f, 5); got != w.typ {
+			t.Errorf("field %s type = %d, want %d", w.name, got, w.typ)
+		}
+		if w.typeName != "" {
+			if got := getString(t, f, 6); got != w.typeName {
+				t.Errorf("field %s type_name = %q, want %q", w.name, got, w.typeName)
+			}
+		}
+	}
+
+	enums := get(file, 5)
+	if len(enums) != 1 {
+		t.Fatalf("%d enums, want 1", len(enums))
+	}
+	if values := get(decode(t, enums[0].bytes), 2); len(values) != 2 {
+		t.Errorf("%d enum values, want 2", len(values))
+	}
+
+	// The location of User.id spans its line and carries its comment.
+	line := slices.Index(strings.Split(source, "\n"), "  string id = 1;")
+	if line < 0 {
+		t.Fatalf("field id not found in:\n%s", source)
+	}
+	loc, ok := findLocation(t, file, []int{pathMessage, 0, pathMessageField, 0})
+	if !ok {
+		t.Fatal("no location for User.id")
+	}
+	if got, want := packed(t, getString(t, loc, 2)), []int{line, 2, len("  string id = 1;")}; !slices.Equal(got, want) {
+		t.Errorf("span = %v, want %v", got, want)
+	}
+	if got := getString(t, loc, 3); got != " The user ID.\n" {
+		t.Errorf("leading comment = %q", got)
+	}
+}
+
+// findLocation returns the SourceCodeInfo location with path.
+func findLocation(t *testing.T, file []wireField, path []int) ([]wireField, bool) {
+	t.Helper()
+	for _, info := range get(file, 9) {
+		for _, l := range get(decode(t, info.bytes), 1) {
+			loc := decode(t, l.bytes)
+			if slices.Equal(packed(t, getString(t, loc, 1)), path) {
+				return loc, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// locations lists the paths and leading comments of the source locations
+// of a file.
+func locations(t *testing.T, file []wireField) []string {
+	t.Helper()
+	var out []string
+	for _, info := range get(file, 9) {
+		for _, l := range get(decode(t, info.bytes), 1) {
+			loc := decode(t, l.bytes)
+			leading := ""
+			if comments := get(loc, 3); len(comments) > 0 {
+				leading = string(comments[0].bytes)
+			}
+			out = append(out, fmt.Sprintf("%v %q", packed(t, getString(t, loc, 1)), leading))
+		}
+	}
+	return out
+}
+
+func TestMarshalAlignedSource(t *testing.T) {
+	plain, _ := marshalFile(t, generator.DefaultOptions())
+	aligned, _ := marshalFile(t, generator.Options{Align: true})
+	want, got := locations(t, plain), locations(t, aligned)
+	if !slices.Equal(got, want) {
+		t.Errorf("aligned source locations differ:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestMarshalHTTPSetResolves(t *testing.T) {
+	p := transformer.Proto{
+		Syntax:  "proto3",
+		Package: "acme.v1",
+		Imports: []string{"google/api/annotations.proto"},
+		Messages: []transformer.ProtoMessage{
+			{Name: "GetUserRequest", Fields: []transformer.ProtoField{{Name: "id", Type: "string", Number: 1}}},
+			{Name: "User", Fields: []transformer.ProtoField{{Name: "id", Type: "string", Number: 1}}},
+		},
+		Services: []transformer.ProtoService{{
+			Name: "UserService",
+			Methods: []transformer.ProtoRPC{{
+				Name: "GetUser", InputType: "GetUserRequest", OutputType: "User",
+				HTTP: &transformer.HTTPRule{Method: "get", Path: "/v1/users/{id}"}, IdempotencyLevel: "NO_SIDE_EFFECTS",
+			}},
+		}},
+	}
+	data, diags := Marshal([]File{{Name: "acme/v1/users.proto", Proto: p}})
+	if len(diags) > 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		t.Fatal(err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := files.FindDescriptorByName("acme.v1.UserService.GetUser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := d.(protoreflect.MethodDescriptor).Options().(*descriptorpb.MethodOptions)
+	if opts.GetIdempotencyLevel() != descriptorpb.MethodOptions_NO_SIDE_EFFECTS {
+		t.Errorf("idempotency_level = %v", opts.GetIdempotencyLevel())
+	}
+	if _, err := files.FindDescriptorByName("google.api.http"); err != nil {
+		t.Errorf("google.api.http: %v", err)
+	}
+}
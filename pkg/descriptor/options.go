@@ -0,0 +1,128 @@
+package descriptor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/transformer"
+)
+
+// optionField is a field of one of the *Options messages in
+// descriptor.proto. Enum-typed fields list their values.
+type optionField struct {
+	number int
+	values map[string]int64
+}
+
+var (
+	optimizeMode     = map[string]int64{"SPEED": 1, "CODE_SIZE": 2, "LITE_RUNTIME": 3}
+	idempotencyLevel = map[string]int64{"IDEMPOTENCY_UNKNOWN": 0, "NO_SIDE_EFFECTS": 1, "IDEMPOTENT": 2}
+	fieldPresence    = map[string]int64{"EXPLICIT": 1, "IMPLICIT": 2, "LEGACY_REQUIRED": 3}
+)
+
+var fileOptions = map[string]optionField{
+	"java_package": {number: 1}, "java_outer_classname": {number: 8},
+	"optimize_for": {number: 9, values: optimizeMode}, "java_multiple_files": {number: 10},
+	"go_package": {number: 11}, "cc_generic_services": {number: 16},
+	"java_generic_services": {number: 17}, "py_generic_services": {number: 18},
+	"java_generate_equals_and_hash": {number: 20}, "deprecated": {number: 23},
+	"java_string_check_utf8": {number: 27}, "cc_enable_arenas": {number: 31},
+	"objc_class_prefix": {number: 36}, "csharp_namespace": {number: 37},
+	"swift_prefix": {number: 39}, "php_class_prefix": {number: 40},
+	"php_namespace": {number: 41}, "php_metadata_namespace": {number: 44},
+	"ruby_package": {number: 45},
+}
+
+var messageOptions = map[string]optionField{
+	"message_set_wire_format": {number: 1}, "no_standard_descriptor_accessor": {number: 2},
+	"deprecated": {number: 3},
+}
+
+var fieldOptions = map[string]optionField{
+	"ctype":  {number: 1, values: map[string]int64{"STRING": 0, "CORD": 1, "STRING_PIECE": 2}},
+	"packed": {number: 2}, "deprecated": {number: 3}, "lazy": {number: 5},
+	"jstype": {number: 6, values: map[string]int64{"JS_NORMAL": 0, "JS_STRING": 1, "JS_NUMBER": 2}},
+	"weak":   {number: 10}, "unverified_lazy": {number: 15}, "debug_redact": {number: 16},
+}
+
+var enumOptions = map[string]optionField{"allow_alias": {number: 2}, "deprecated": {number: 3}}
+
+var enumValueOptions = map[string]optionField{"deprecated": {number: 1}, "debug_redact": {number: 3}}
+
+var serviceOptions = map[string]optionField{"deprecated": {number: 33}}
+
+var methodOptions = map[string]optionField{
+	"deprecated": {number: 33}, "idempotency_level": {number: 34, values: idempotencyLevel},
+}
+
+// httpExtension is the field number of (google.api.http) in
+// MethodOptions, and httpMethods the HttpRule field of each method.
+const httpExtension = 72295728
+
+var httpMethods = map[string]int{"get": 2, "put": 3, "post": 4, "delete": 5, "patch": 6}
+
+// options encodes an *Options message, with fields in number order, or
+// returns nil if there is nothing to encode. featuresField is the number
+// of its features field. Custom options are left out with a warning:
+// their definitions are in files go2proto does not read.
+func (b *builder) options(element string, fields map[string]optionField, featuresField int, options []transformer.ProtoOption) []byte {
+	type encoded struct {
+		number int
+		encode func(*encoder)
+	}
+	var out []encoded
+	for _, o := range options {
+		if o.Name == "features.field_presence" {
+			value, ok := fieldPresence[o.Value.Value]
+			if !ok {
+				b.warn(element, fmt.Sprintf("unknown field presence %s is not encoded", o.Value))
+				continue
+			}
+			out = append(out, encoded{featuresField, func(e *encoder) {
+				e.message(featuresField, func(fe *encoder) { fe.int(1, value) })
+			}})
+			continue
+		}
+		field, ok := fields[o.Name]
+		if !ok {
+			b.warn(element, fmt.Sprintf("option %s is not encoded in the descriptor set", o.Name))
+			continue
+		}
+		encode, err := encodeOption(field, o.Value)
+		if err != nil {
+			b.warn(element, fmt.Sprintf("option %s: %v", o.Name, err))
+			continue
+		}
+		out = append(out, encoded{field.number, encode})
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].number < out[j].number })
+	var e encoder
+	for _, o := range out {
+		o.encode(&e)
+	}
+	return e.b
+}
+
+func encodeOption(field optionField, v transformer.OptionValue) (func(*encoder), error) {
+	switch {
+	case field.values != nil:
+		n, ok := field.values[v.Value]
+		if !ok {
+			return nil, fmt.Errorf("unknown value %s", v)
+		}
+		return func(e *encoder) { e.int(field.number, n) }, nil
+	case v.Kind == transformer.OptionBool:
+		return func(e *encoder) { e.bool(field.number, v.Value == "true") }, nil
+	case v.Kind == transformer.OptionInt:
+		n, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(e *encoder) { e.int(field.number, n) }, nil
+	}
+	return func(e *encoder) { e.string(field.number, v.Value) }, nil
+}
@@ -0,0 +1,263 @@
+package descriptor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/transformer"
+)
+
+// location is a SourceCodeInfo.Location: the span of a declaration,
+// identified by its field path in the FileDescriptorProto, and its
+// comments.
+type location struct {
+	path     []int
+	span     []int
+	leading  string
+	detached []string
+}
+
+// Field numbers of the paths in SourceCodeInfo.
+const (
+	pathPackage    = 2
+	pathDependency = 3
+	pathMessage    = 4
+	pathEnum       = 5
+	pathService    = 6
+	pathSyntax     = 12
+	pathEdition    = 14
+
+	pathMessageField  = 2
+	pathMessageNested = 3
+	pathMessageEnum   = 4
+	pathMessageOneof  = 8
+	pathEnumValue     = 2
+	pathServiceMethod = 2
+)
+
+// sourceInfo encodes the SourceCodeInfo of a generated file. Declarations
+// are located in the rendered text by name, so the order the generator
+// writes them in does not matter.
+func sourceInfo(e *encoder, f entry) {
+	src := sourceFile{lines: strings.Split(strings.TrimRight(f.source, "\n"), "\n")}
+	last := len(src.lines) - 1
+	locs := []location{{span: []int{0, 0, last, len(src.lines[last])}}}
+
+	if f.proto.Edition != "" {
+		locs = src.statement(locs, []int{pathEdition}, `^edition = `)
+	} else {
+		locs = src.statement(locs, []int{pathSyntax}, `^syntax = `)
+	}
+	locs = src.statement(locs, []int{pathPackage}, `^package `)
+	for i, dep := range sortedImports(f.proto) {
+		locs = src.statement(locs, []int{pathDependency, i}, `^import "`+regexp.QuoteMeta(dep)+`";`)
+	}
+
+	whole := src.whole()
+	for i, en := range f.proto.Enums {
+		locs = src.enum(locs, whole, []int{pathEnum, i}, en)
+	}
+	for i, m := range f.proto.Messages {
+		locs = src.message(locs, whole, []int{pathMessage, i}, m)
+	}
+	for i, s := range f.proto.Services {
+		decl, ok := src.find(whole, `^\s*service `+regexp.QuoteMeta(s.Name)+` \{`)
+		if !ok {
+			continue
+		}
+		path := []int{pathService, i}
+		locs = append(locs, src.location(path, decl))
+		for j, r := range s.Methods {
+			if rpc, ok := src.find(decl, `^\s*rpc `+regexp.QuoteMeta(r.Name)+`\(`); ok {
+				locs = append(locs, src.location(child(path, pathServiceMethod, j), rpc))
+			}
+		}
+	}
+
+	for _, loc := range locs {
+		e.message(1, func(le *encoder) {
+			le.packed(1, loc.path)
+			le.packed(2, loc.span)
+			le.optString(3, loc.leading)
+			for _, d := range loc.detached {
+				le.string(6, d)
+			}
+		})
+	}
+}
+
+func (s sourceFile) statement(locs []location, path []int, pattern string) []location {
+	if decl, ok := s.find(s.whole(), pattern); ok {
+		return append(locs, s.location(path, decl))
+	}
+	return locs
+}
+
+func (s sourceFile) message(locs []location, parent block, path []int, m transformer.ProtoMessage) []location {
+	decl, ok := s.find(parent, `^\s*message `+regexp.QuoteMeta(m.Name)+` \{`)
+	if !ok {
+		return locs
+	}
+	locs = append(locs, s.location(path, decl))
+	for i, f := range m.Fields {
+		if field, ok := s.find(decl, fmt.Sprintf(`\b%s\s+=\s+%d\b`, regexp.QuoteMeta(f.Name), f.Number)); ok {
+			locs = append(locs, s.location(child(path, pathMessageField, i), field))
+		}
+	}
+	for i, nested := range m.Nested {
+		locs = s.message(locs, decl, child(path, pathMessageNested, i), nested)
+	}
+	for i, en := range m.Enums {
+		locs = s.enum(locs, decl, child(path, pathMessageEnum, i), en)
+	}
+	for i, o := range m.Oneofs {
+		if oneof, ok := s.find(decl, `^\s*oneof `+regexp.QuoteMeta(o.Name)+` \{`); ok {
+			locs = append(locs, s.location(child(path, pathMessageOneof, i), oneof))
+		}
+	}
+	return locs
+}
+
+func (s sourceFile) enum(locs []location, parent block, path []int, en transformer.ProtoEnum) []location {
+	decl, ok := s.find(parent, `^\s*enum `+regexp.QuoteMeta(en.Name)+` \{`)
+	if !ok {
+		return locs
+	}
+	locs = append(locs, s.location(path, decl))
+	for i, v := range en.Values {
+		if value, ok := s.find(decl, fmt.Sprintf(`^\s*%s\s+=\s+%d\b`, regexp.QuoteMeta(v.Name), v.Number)); ok {
+			locs = append(locs, s.location(child(path, pathEnumValue, i), value))
+		}
+	}
+	return locs
+}
+
+func child(path []int, field, index int) []int {
+	return append(append([]int(nil), path...), field, index)
+}
+
+// sourceFile is the rendered text of a .proto file.
+type sourceFile struct {
+	lines []string
+}
+
+// block is the range of lines a declaration spans, from the line that
+// opens it to the line that closes it.
+type block struct {
+	start, end int
+}
+
+// whole is the file as a block, as if it were enclosed in braces.
+func (s sourceFile) whole() block { return block{-1, len(s.lines)} }
+
+// find returns the first declaration in parent matching pattern, looking
+// only at its direct members and the members of its oneofs.
+func (s sourceFile) find(parent block, pattern string) (block, bool) {
+	re := regexp.MustCompile(pattern)
+	var open []bool // whether each open block is a oneof
+	for i := parent.start + 1; i < parent.end; i++ {
+		line := s.lines[i]
+		direct := !strings.HasPrefix(strings.TrimSpace(line), "//")
+		for _, isOneof := range open {
+			direct = direct && isOneof
+		}
+		if direct && re.MatchString(line) {
+			return block{i, s.end(i)}, true
+		}
+		for _, delta := range braces(line) {
+			if delta > 0 {
+				open = append(open, strings.HasPrefix(strings.TrimSpace(line), "oneof "))
+			} else if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return block{}, false
+}
+
+// end returns the last line of the declaration starting at line start.
+func (s sourceFile) end(start int) int {
+	depth := 0
+	for i := start; i < len(s.lines); i++ {
+		for _, delta := range braces(s.lines[i]) {
+			depth += delta
+		}
+		if depth <= 0 {
+			return i
+		}
+	}
+	return len(s.lines) - 1
+}
+
+// braces lists the { and [ (+1) and } and ] (-1) of a line outside
+// strings and comments. Counting brackets makes a field whose options
+// span several lines end at its ];.
+func braces(line string) []int {
+	var out []int
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '/' && strings.HasPrefix(line[i:], "//"):
+			return out
+		case c == '{' || c == '[':
+			out = append(out, 1)
+		case c == '}' || c == ']':
+			out = append(out, -1)
+		}
+	}
+	return out
+}
+
+// location builds the location of a declaration: its span, from the
+// first character to the end of its last line, and the comments above it.
+func (s sourceFile) location(path []int, decl block) location {
+	first, last := s.lines[decl.start], s.lines[decl.end]
+	startCol := len(first) - len(strings.TrimLeft(first, " "))
+	span := []int{decl.start, startCol, decl.end, len(last)}
+	if decl.start == decl.end {
+		span = []int{decl.start, startCol, len(last)}
+	}
+	loc := location{path: path, span: span}
+
+	// The comment directly above is leading; comment blocks separated
+	// from it by blank lines are detached.
+	leading, i := s.commentAbove(decl.start - 1)
+	loc.leading = leading
+	for {
+		j := i
+		for j >= 0 && strings.TrimSpace(s.lines[j]) == "" {
+			j--
+		}
+		if j == i {
+			break
+		}
+		comment, j := s.commentAbove(j)
+		if comment == "" {
+			break
+		}
+		loc.detached = append([]string{comment}, loc.detached...)
+		i = j
+	}
+	return loc
+}
+
+// commentAbove collects the // comment lines ending at line i in protoc's
+// format, each line without its // and with a trailing newline. It
+// returns the line above the comment.
+func (s sourceFile) commentAbove(i int) (string, int) {
+	var lines []string
+	for ; i >= 0; i-- {
+		text, ok := strings.CutPrefix(strings.TrimSpace(s.lines[i]), "//")
+		if !ok {
+			break
+		}
+		lines = append([]string{text + "\n"}, lines...)
+	}
+	return strings.Join(lines, ""), i
+}
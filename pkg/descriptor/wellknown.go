@@ -0,0 +1,142 @@
+package descriptor
+
+import "github.com/vinodhalaharvi/go2proto/pkg/transformer"
+
+// extension is a top-level extend declaration. The Proto IR has none, so
+// the few the well-known files need are listed separately.
+type extension struct {
+	name     string
+	number   int
+	typeName string // fully qualified, e.g. .google.api.HttpRule
+	extendee string
+}
+
+// wellKnownFile is a dependency go2proto can describe itself.
+type wellKnownFile struct {
+	proto      transformer.Proto
+	extensions []extension
+	// extensionRanges maps fully qualified message names to the
+	// [start, end) range of extension numbers they accept.
+	extensionRanges map[string][2]int
+}
+
+// descriptorProto is imported by google/api/annotations.proto. Only
+// MethodOptions, which (google.api.http) extends, is described.
+const descriptorProto = "google/protobuf/descriptor.proto"
+
+// maxFieldNumber is the largest field number, max in extension ranges.
+const maxFieldNumber = 1<<29 - 1
+
+func scalarMessage(name, fieldType string) transformer.ProtoMessage {
+	return transformer.ProtoMessage{Name: name, Fields: []transformer.ProtoField{{Name: "value", Type: fieldType, Number: 1}}}
+}
+
+func wellKnown(pkg string, imports []string, messages []transformer.ProtoMessage, enums ...transformer.ProtoEnum) wellKnownFile {
+	return wellKnownFile{proto: transformer.Proto{
+		Syntax: "proto3", Package: pkg, Imports: imports, Messages: messages, Enums: enums,
+	}}
+}
+
+// wellKnownFiles describes the files go2proto's type mappings and
+// directives import.
+var wellKnownFiles = map[string]wellKnownFile{
+	"google/protobuf/any.proto": wellKnown("google.protobuf", nil, []transformer.ProtoMessage{{
+		Name: "Any", Fields: []transformer.ProtoField{
+			{Name: "type_url", Type: "string", Number: 1},
+			{Name: "value", Type: "bytes", Number: 2},
+		},
+	}}),
+	"google/protobuf/duration.proto": wellKnown("google.protobuf", nil, []transformer.ProtoMessage{{
+		Name: "Duration", Fields: []transformer.ProtoField{
+			{Name: "seconds", Type: "int64", Number: 1},
+			{Name: "nanos", Type: "int32", Number: 2},
+		},
+	}}),
+	"google/protobuf/empty.proto": wellKnown("google.protobuf", nil, []transformer.ProtoMessage{{Name: "Empty"}}),
+	"google/protobuf/field_mask.proto": wellKnown("google.protobuf", nil, []transformer.ProtoMessage{{
+		Name: "FieldMask", Fields: []transformer.ProtoField{{Name: "paths", Type: "string", Number: 1, Repeated: true}},
+	}}),
+	"google/protobuf/struct.proto": wellKnown("google.protobuf", nil, []transformer.ProtoMessage{
+		{Name: "Struct", Fields: []transformer.ProtoField{
+			{Name: "fields", Number: 1, MapKey: "string", MapValue: "Value"},
+		}},
+		{Name: "Value", Oneofs: []transformer.ProtoOneof{{Name: "kind"}}, Fields: []transformer.ProtoField{
+			{Name: "null_value", Type: "NullValue", Number: 1, Oneof: "kind"},
+			{Name: "number_value", Type: "double", Number: 2, Oneof: "kind"},
+			{Name: "string_value", Type: "string", Number: 3, Oneof: "kind"},
+			{Name: "bool_value", Type: "bool", Number: 4, Oneof: "kind"},
+			{Name: "struct_value", Type: "Struct", Number: 5, Oneof: "kind"},
+			{Name: "list_value", Type: "ListValue", Number: 6, Oneof: "kind"},
+		}},
+		{Name: "ListValue", Fields: []transformer.ProtoField{{Name: "values", Type: "Value", Number: 1, Repeated: true}}},
+	}, transformer.ProtoEnum{Name: "NullValue", Values: []transformer.ProtoEnumValue{{Name: "NULL_VALUE", Number: 0}}}),
+	"google/protobuf/timestamp.proto": wellKnown("google.protobuf", nil, []transformer.ProtoMessage{{
+		Name: "Timestamp", Fields: []transformer.ProtoField{
+			{Name: "seconds", Type: "int64", Number: 1},
+			{Name: "nanos", Type: "int32", Number: 2},
+		},
+	}}),
+	"google/protobuf/wrappers.proto": wellKnown("google.protobuf", nil, []transformer.ProtoMessage{
+		scalarMessage("DoubleValue", "double"), scalarMessage("FloatValue", "float"),
+		scalarMessage("Int64Value", "int64"), scalarMessage("UInt64Value", "uint64"),
+		scalarMessage("Int32Value", "int32"), scalarMessage("UInt32Value", "uint32"),
+		scalarMessage("BoolValue", "bool"), scalarMessage("StringValue", "string"),
+		scalarMessage("BytesValue", "bytes"),
+	}),
+	"google/rpc/status.proto": wellKnown("google.rpc", []string{"google/protobuf/any.proto"}, []transformer.ProtoMessage{{
+		Name: "Status", Fields: []transformer.ProtoField{
+			{Name: "code", Type: "int32", Number: 1},
+			{Name: "message", Type: "string", Number: 2},
+			{Name: "details", Type: "google.protobuf.Any", Number: 3, Repeated: true},
+		},
+	}}),
+	"google/api/http.proto": wellKnown("google.api", nil, []transformer.ProtoMessage{
+		{Name: "Http", Fields: []transformer.ProtoField{
+			{Name: "rules", Type: "HttpRule", Number: 1, Repeated: true},
+			{Name: "fully_decode_reserved_expansion", Type: "bool", Number: 2},
+		}},
+		{Name: "HttpRule", Oneofs: []transformer.ProtoOneof{{Name: "pattern"}}, Fields: []transformer.ProtoField{
+			{Name: "selector", Type: "string", Number: 1},
+			{Name: "get", Type: "string", Number: 2, Oneof: "pattern"},
+			{Name: "put", Type: "string", Number: 3, Oneof: "pattern"},
+			{Name: "post", Type: "string", Number: 4, Oneof: "pattern"},
+			{Name: "delete", Type: "string", Number: 5, Oneof: "pattern"},
+			{Name: "patch", Type: "string", Number: 6, Oneof: "pattern"},
+			{Name: "custom", Type: "CustomHttpPattern", Number: 8, Oneof: "pattern"},
+			{Name: "body", Type: "string", Number: 7},
+			{Name: "response_body", Type: "string", Number: 12},
+			{Name: "additional_bindings", Type: "HttpRule", Number: 11, Repeated: true},
+		}},
+		{Name: "CustomHttpPattern", Fields: []transformer.ProtoField{
+			{Name: "kind", Type: "string", Number: 1},
+			{Name: "path", Type: "string", Number: 2},
+		}},
+	}),
+	descriptorProto: {
+		proto: transformer.Proto{
+			Syntax: "proto2", Package: "google.protobuf",
+			Messages: []transformer.ProtoMessage{{
+				Name: "MethodOptions",
+				Fields: []transformer.ProtoField{
+					{Name: "deprecated", Type: "bool", Number: 33, Optional: true},
+					{Name: "idempotency_level", Type: "IdempotencyLevel", Number: 34, Optional: true},
+				},
+				Enums: []transformer.ProtoEnum{{Name: "IdempotencyLevel", Values: []transformer.ProtoEnumValue{
+					{Name: "IDEMPOTENCY_UNKNOWN", Number: 0},
+					{Name: "NO_SIDE_EFFECTS", Number: 1},
+					{Name: "IDEMPOTENT", Number: 2},
+				}}},
+			}},
+		},
+		extensionRanges: map[string][2]int{"google.protobuf.MethodOptions": {1000, maxFieldNumber + 1}},
+	},
+	"google/api/annotations.proto": {
+		proto: transformer.Proto{
+			Syntax: "proto3", Package: "google.api",
+			Imports: []string{"google/api/http.proto", descriptorProto},
+		},
+		extensions: []extension{{
+			name: "http", number: httpExtension, typeName: ".google.api.HttpRule", extendee: ".google.protobuf.MethodOptions",
+		}},
+	},
+}
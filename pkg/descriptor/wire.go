@@ -0,0 +1,74 @@
+package descriptor
+
+// Wire types of the protobuf binary encoding.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// encoder appends protobuf wire-format fields to a byte slice.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) varint(v uint64) {
+	for v >= 0x80 {
+		e.b = append(e.b, byte(v)|0x80)
+		v >>= 7
+	}
+	e.b = append(e.b, byte(v))
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// int encodes an int32, int64 or enum field. Negative values take ten
+// bytes, as in the reference implementation.
+func (e *encoder) int(field int, v int64) {
+	e.tag(field, wireVarint)
+	e.varint(uint64(v))
+}
+
+func (e *encoder) bool(field int, v bool) {
+	e.tag(field, wireVarint)
+	if v {
+		e.varint(1)
+	} else {
+		e.varint(0)
+	}
+}
+
+func (e *encoder) bytes(field int, v []byte) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) string(field int, v string) {
+	e.bytes(field, []byte(v))
+}
+
+// optString writes a string field unless it is empty.
+func (e *encoder) optString(field int, v string) {
+	if v != "" {
+		e.string(field, v)
+	}
+}
+
+// message writes a length-delimited sub-message built by f. Empty
+// messages are still written, since their presence can matter.
+func (e *encoder) message(field int, f func(*encoder)) {
+	var sub encoder
+	f(&sub)
+	e.bytes(field, sub.b)
+}
+
+// packed writes a packed repeated int32 field.
+func (e *encoder) packed(field int, vs []int) {
+	var sub encoder
+	for _, v := range vs {
+		sub.varint(uint64(int64(v)))
+	}
+	e.bytes(field, sub.b)
+}
@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/ct"
+)
+
+// comments renders doc comment lines at the given indentation. Blank lines
+// separate paragraphs and are written as a bare //. Indented lines, such as
+// code blocks, keep their layout with tabs expanded. With a CommentWidth,
+// paragraphs are re-wrapped to fit it.
+func (g *Generator) comments(indent string, lines []string) Code {
+	lines = paragraphs(lines)
+	if g.opts.CommentWidth > 0 {
+		lines = wrapParagraphs(lines, g.opts.CommentWidth-len(indent)-len("// "))
+	}
+	return ct.FoldMap(lines, CodeMonoid, func(line string) Code {
+		if line == "" {
+			return Line(indent + "//")
+		}
+		return Line(indent + "// " + expandIndent(line))
+	})
+}
+
+// paragraphs drops leading and trailing blank lines and collapses runs of
+// blank lines, which directive lines removed from a comment can leave.
+func paragraphs(lines []string) []string {
+	var out []string
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			if len(out) > 0 && out[len(out)-1] != "" {
+				out = append(out, "")
+			}
+			continue
+		}
+		out = append(out, line)
+	}
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// wrapParagraphs refills runs of unindented lines to width. Indented
+// lines are preformatted and left alone.
+func wrapParagraphs(lines []string, width int) []string {
+	var out, words []string
+	flush := func() {
+		line := ""
+		for _, word := range words {
+			if line != "" && len(line)+1+len(word) > width {
+				out = append(out, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		if line != "" {
+			out = append(out, line)
+		}
+		words = nil
+	}
+	for _, line := range lines {
+		if line == "" || isPreformatted(line) {
+			flush()
+			out = append(out, line)
+			continue
+		}
+		words = append(words, strings.Fields(line)...)
+	}
+	flush()
+	return out
+}
+
+func isPreformatted(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// expandIndent replaces leading tabs with two spaces each.
+func expandIndent(line string) string {
+	trimmed := strings.TrimLeft(line, "\t")
+	return strings.Repeat("  ", len(line)-len(trimmed)) + trimmed
+}
@@ -3,6 +3,7 @@ package generator
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -38,40 +39,86 @@ func Indent(c Code) Code {
 	return Code{Lines: indented}
 }
 
-// Comment creates a comment line.
+// Comment creates comment lines for s, rendered like doc comments with
+// the default options.
 func Comment(s string) Code {
-	if s == "" {
-		return CodeMonoid.Empty()
-	}
-	return Line("// " + s)
+	return NewGenerator().comments("", strings.Split(s, "\n"))
 }
 
 // String converts Code to string.
 func (c Code) String() string { return strings.Join(c.Lines, "\n") }
 
 // Generator renders Proto to .proto format.
-type Generator struct{}
+type Generator struct {
+	opts Options
+}
+
+// Options configures rendering. The default output is a fixed point of
+// buf format.
+type Options struct {
+	// CommentWidth wraps comment paragraphs to this many columns,
+	// including indentation. Zero keeps the original line breaks.
+	CommentWidth int
+	// Align pads field types and names, and enum value names, so the
+	// names and numbers of a block line up in columns. buf format undoes
+	// the padding.
+	Align bool
+}
 
-// NewGenerator creates a new generator.
-func NewGenerator() *Generator { return &Generator{} }
+// DefaultOptions returns sensible defaults.
+func DefaultOptions() Options { return Options{} }
 
-// Generate renders a Proto to .proto content.
+// NewGenerator creates a new generator with the default options.
+func NewGenerator() *Generator { return NewGeneratorWithOptions(DefaultOptions()) }
+
+// NewGeneratorWithOptions creates a new generator with opts.
+func NewGeneratorWithOptions(opts Options) *Generator { return &Generator{opts: opts} }
+
+// Generate renders a Proto to .proto content. Sections follow buf
+// format's order: syntax, package, imports, options, then declarations.
 func (g *Generator) Generate(p transformer.Proto) string {
-	code := ct.Concat(CodeMonoid, []Code{
+	code := blocks([]Code{
+		g.comments("", p.Doc),
 		g.renderHeader(p),
-		Blank(),
 		g.renderPackage(p),
-		Blank(),
-		g.renderOptions(p),
 		g.renderImports(p),
+		g.renderOptions(p),
 		g.renderEnums(p),
 		g.renderMessages(p),
 		g.renderServices(p),
 	})
-	return code.String()
+	return code.String() + "\n"
+}
+
+// blocks joins the non-empty blocks with one blank line between each.
+func blocks(codes []Code) Code {
+	joined := CodeMonoid.Empty()
+	for _, c := range codes {
+		if len(c.Lines) == 0 {
+			continue
+		}
+		if len(joined.Lines) > 0 {
+			joined = CodeMonoid.Append(joined, Blank())
+		}
+		joined = CodeMonoid.Append(joined, c)
+	}
+	return joined
+}
+
+// body renders a declaration with the given blocks, already indented,
+// between its braces, or with empty braces on one line if there are none.
+func body(head string, codes []Code) Code {
+	inner := blocks(codes)
+	if len(inner.Lines) == 0 {
+		return Line(head + " {}")
+	}
+	return ct.Concat(CodeMonoid, []Code{Line(head + " {"), inner, Line("}")})
 }
 
 func (g *Generator) renderHeader(p transformer.Proto) Code {
+	if p.Edition != "" {
+		return Line(fmt.Sprintf(`edition = "%s";`, p.Edition))
+	}
 	syntax := p.Syntax
 	if syntax == "" {
 		syntax = "proto3"
@@ -87,114 +134,162 @@ func (g *Generator) renderPackage(p transformer.Proto) Code {
 }
 
 func (g *Generator) renderOptions(p transformer.Proto) Code {
-	if len(p.Options) == 0 {
-		return CodeMonoid.Empty()
-	}
 	keys := make([]string, 0, len(p.Options))
 	for k := range p.Options {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
-	lines := ct.FoldMap(keys, CodeMonoid, func(k string) Code {
-		return Line(fmt.Sprintf(`option %s = "%s";`, k, p.Options[k]))
+	return ct.FoldMap(keys, CodeMonoid, func(k string) Code {
+		return Line(fmt.Sprintf(`option %s = %s;`, k, p.Options[k]))
 	})
-	return ct.Concat(CodeMonoid, []Code{lines, Blank()})
 }
 
 func (g *Generator) renderImports(p transformer.Proto) Code {
-	if len(p.Imports) == 0 {
-		return CodeMonoid.Empty()
-	}
 	imports := make([]string, len(p.Imports))
 	copy(imports, p.Imports)
 	sort.Strings(imports)
-	lines := ct.FoldMap(imports, CodeMonoid, func(imp string) Code {
+	return ct.FoldMap(imports, CodeMonoid, func(imp string) Code {
 		return Line(fmt.Sprintf(`import "%s";`, imp))
 	})
-	return ct.Concat(CodeMonoid, []Code{lines, Blank()})
 }
 
 func (g *Generator) renderEnums(p transformer.Proto) Code {
-	if len(p.Enums) == 0 {
-		return CodeMonoid.Empty()
-	}
-	return ct.FoldMap(p.Enums, CodeMonoid, g.renderEnum)
+	return blocks(ct.Map(p.Enums, g.renderEnum))
 }
 
 func (g *Generator) renderEnum(e transformer.ProtoEnum) Code {
-	comments := ct.FoldMap(e.Comments, CodeMonoid, Comment)
+	comments := g.comments("", e.Comments)
+	cols := g.enumColumns(e.Values)
 	values := ct.FoldMap(e.Values, CodeMonoid, func(v transformer.ProtoEnumValue) Code {
-		valueComments := ct.FoldMap(v.Comments, CodeMonoid, func(c string) Code {
-			return Line("  // " + c)
-		})
-		valueLine := Line(fmt.Sprintf("  %s = %d;", v.Name, v.Number))
-		return ct.Concat(CodeMonoid, []Code{valueComments, valueLine})
+		decl := fmt.Sprintf("%-*s = %d", cols.name, v.Name, v.Number)
+		return ct.Concat(CodeMonoid, []Code{g.comments("  ", v.Comments), Indent(compactOptions(decl, v.Deprecated, v.Options))})
 	})
+	options := blockOptions(e.Deprecated, e.Options)
+	if e.AllowAlias {
+		options = ct.Concat(CodeMonoid, []Code{Line("  option allow_alias = true;"), options})
+	}
 	return ct.Concat(CodeMonoid, []Code{
-		comments, Line(fmt.Sprintf("enum %s {", e.Name)), values, Line("}"), Blank(),
+		comments, body(fmt.Sprintf("enum %s", e.Name), []Code{options, values}),
 	})
 }
 
 func (g *Generator) renderMessages(p transformer.Proto) Code {
-	if len(p.Messages) == 0 {
-		return CodeMonoid.Empty()
-	}
-	return ct.FoldMap(p.Messages, CodeMonoid, g.renderMessage)
+	return blocks(ct.Map(p.Messages, g.renderMessage))
 }
 
+// renderMessage renders a message: its options, then each nested enum and
+// message, then its fields, as blocks separated by blank lines.
 func (g *Generator) renderMessage(m transformer.ProtoMessage) Code {
-	comments := ct.FoldMap(m.Comments, CodeMonoid, Comment)
-	fields := ct.FoldMap(m.Fields, CodeMonoid, g.renderField)
-	nestedEnums := ct.FoldMap(m.Enums, CodeMonoid, func(e transformer.ProtoEnum) Code {
-		return Indent(g.renderEnum(e))
-	})
-	nestedMessages := ct.FoldMap(m.Nested, CodeMonoid, func(nested transformer.ProtoMessage) Code {
-		return Indent(g.renderMessage(nested))
-	})
-	return ct.Concat(CodeMonoid, []Code{
-		comments, Line(fmt.Sprintf("message %s {", m.Name)),
-		nestedEnums, nestedMessages, fields, Line("}"), Blank(),
-	})
+	comments := g.comments("", m.Comments)
+	nestedEnums := ct.Map(m.Enums, func(e transformer.ProtoEnum) Code { return Indent(g.renderEnum(e)) })
+	nestedMessages := ct.Map(m.Nested, func(nested transformer.ProtoMessage) Code { return Indent(g.renderMessage(nested)) })
+	inner := append([]Code{blockOptions(m.Deprecated, m.Options)}, nestedEnums...)
+	inner = append(append(inner, nestedMessages...), g.renderFields(m))
+	return ct.Concat(CodeMonoid, []Code{comments, body(fmt.Sprintf("message %s", m.Name), inner)})
 }
 
-func (g *Generator) renderField(f transformer.ProtoField) Code {
-	comments := ct.FoldMap(f.Comments, CodeMonoid, func(c string) Code {
-		return Line("  // " + c)
-	})
-	var fieldLine string
-	if f.MapKey != "" && f.MapValue != "" {
-		fieldLine = fmt.Sprintf("  map<%s, %s> %s = %d;", f.MapKey, f.MapValue, f.Name, f.Number)
-	} else {
-		prefix := ""
-		if f.Repeated {
-			prefix = "repeated "
-		} else if f.Optional {
-			prefix = "optional "
+// renderFields renders message fields in order. Members of a oneof are
+// rendered together as a block at the position of the first member.
+func (g *Generator) renderFields(m transformer.ProtoMessage) Code {
+	declared := make(map[string]bool)
+	for _, o := range m.Oneofs {
+		declared[o.Name] = true
+	}
+	inOneof := func(f transformer.ProtoField) bool { return f.Oneof != "" && declared[f.Oneof] }
+	cols := g.fieldColumns(ct.Filter(m.Fields, func(f transformer.ProtoField) bool { return !inOneof(f) }))
+	rendered := make(map[string]bool)
+	return ct.FoldMap(m.Fields, CodeMonoid, func(f transformer.ProtoField) Code {
+		if !inOneof(f) {
+			return g.renderField(f, cols)
 		}
-		fieldLine = fmt.Sprintf("  %s%s %s = %d;", prefix, f.Type, f.Name, f.Number)
+		if rendered[f.Oneof] {
+			return CodeMonoid.Empty()
+		}
+		rendered[f.Oneof] = true
+		members := ct.Filter(m.Fields, func(o transformer.ProtoField) bool { return o.Oneof == f.Oneof })
+		memberCols := g.fieldColumns(members)
+		return ct.Concat(CodeMonoid, []Code{
+			Line(fmt.Sprintf("  oneof %s {", f.Oneof)),
+			Indent(ct.FoldMap(members, CodeMonoid, func(o transformer.ProtoField) Code { return g.renderField(o, memberCols) })),
+			Line("  }"),
+		})
+	})
+}
+
+func (g *Generator) renderField(f transformer.ProtoField, cols columns) Code {
+	comments := g.comments("  ", f.Comments)
+	options := f.Options
+	if f.Default != nil {
+		options = append([]transformer.ProtoOption{{Name: "default", Value: *f.Default}}, options...)
 	}
-	return ct.Concat(CodeMonoid, []Code{comments, Line(fieldLine)})
+	decl := fmt.Sprintf("%-*s %-*s = %d", cols.typ, fieldType(f), cols.name, f.Name, f.Number)
+	return ct.Concat(CodeMonoid, []Code{comments, Indent(compactOptions(decl, f.Deprecated, options))})
 }
 
-func (g *Generator) renderServices(p transformer.Proto) Code {
-	if len(p.Services) == 0 {
-		return CodeMonoid.Empty()
+// fieldType returns the type of a field with its label, e.g.
+// "repeated string" or "map<string, int64>".
+func fieldType(f transformer.ProtoField) string {
+	switch {
+	case f.MapKey != "" && f.MapValue != "":
+		return fmt.Sprintf("map<%s, %s>", f.MapKey, f.MapValue)
+	case f.Repeated:
+		return "repeated " + f.Type
+	case f.Required:
+		return "required " + f.Type
+	case f.Optional:
+		return "optional " + f.Type
+	}
+	return f.Type
+}
+
+// columns holds the widths field types and names are padded to. They
+// are zero unless Align is set.
+type columns struct {
+	typ, name int
+}
+
+func (g *Generator) fieldColumns(fields []transformer.ProtoField) columns {
+	var cols columns
+	if !g.opts.Align {
+		return cols
 	}
-	return ct.FoldMap(p.Services, CodeMonoid, g.renderService)
+	for _, f := range fields {
+		cols.typ = max(cols.typ, len(fieldType(f)))
+		cols.name = max(cols.name, len(f.Name))
+	}
+	return cols
+}
+
+func (g *Generator) enumColumns(values []transformer.ProtoEnumValue) columns {
+	var cols columns
+	if !g.opts.Align {
+		return cols
+	}
+	for _, v := range values {
+		cols.name = max(cols.name, len(v.Name))
+	}
+	return cols
+}
+
+func (g *Generator) renderServices(p transformer.Proto) Code {
+	return blocks(ct.Map(p.Services, g.renderService))
 }
 
 func (g *Generator) renderService(s transformer.ProtoService) Code {
-	comments := ct.FoldMap(s.Comments, CodeMonoid, Comment)
+	lines := s.Comments
+	if len(s.ErrorDetails) > 0 {
+		lines = append(slices.Clone(lines), "", fmt.Sprintf(
+			"Errors are returned as google.rpc.Status with details of type %s.", strings.Join(s.ErrorDetails, ", ")))
+	}
+	comments := g.comments("", lines)
 	methods := ct.FoldMap(s.Methods, CodeMonoid, g.renderRPC)
 	return ct.Concat(CodeMonoid, []Code{
-		comments, Line(fmt.Sprintf("service %s {", s.Name)), methods, Line("}"), Blank(),
+		comments, body(fmt.Sprintf("service %s", s.Name), []Code{blockOptions(s.Deprecated, s.Options), methods}),
 	})
 }
 
 func (g *Generator) renderRPC(r transformer.ProtoRPC) Code {
-	comments := ct.FoldMap(r.Comments, CodeMonoid, func(c string) Code {
-		return Line("  // " + c)
-	})
+	comments := g.comments("  ", r.Comments)
 	inputType := r.InputType
 	if r.ClientStreaming {
 		inputType = "stream " + inputType
@@ -203,6 +298,64 @@ func (g *Generator) renderRPC(r transformer.ProtoRPC) Code {
 	if r.ServerStreaming {
 		outputType = "stream " + outputType
 	}
-	rpcLine := fmt.Sprintf("  rpc %s(%s) returns (%s);", r.Name, inputType, outputType)
-	return ct.Concat(CodeMonoid, []Code{comments, Line(rpcLine)})
+	rpcLine := fmt.Sprintf("rpc %s(%s) returns (%s)", r.Name, inputType, outputType)
+	options := ct.Concat(CodeMonoid, []Code{
+		blockOptions(r.Deprecated, r.Options), renderIdempotencyLevel(r.IdempotencyLevel), renderHTTPRule(r.HTTP),
+	})
+	if len(options.Lines) == 0 {
+		return ct.Concat(CodeMonoid, []Code{comments, Line("  " + rpcLine + ";")})
+	}
+	return ct.Concat(CodeMonoid, []Code{comments, Indent(body(rpcLine, []Code{options}))})
+}
+
+func renderIdempotencyLevel(level string) Code {
+	if level == "" {
+		return CodeMonoid.Empty()
+	}
+	return Line(fmt.Sprintf("  option idempotency_level = %s;", level))
+}
+
+// renderHTTPRule renders the google.api.http option of an RPC.
+func renderHTTPRule(rule *transformer.HTTPRule) Code {
+	if rule == nil {
+		return CodeMonoid.Empty()
+	}
+	body := Line(fmt.Sprintf("    %s: %q", rule.Method, rule.Path))
+	if rule.Body != "" {
+		body = ct.Concat(CodeMonoid, []Code{body, Line(fmt.Sprintf("    body: %q", rule.Body))})
+	}
+	if rule.ResponseBody != "" {
+		body = ct.Concat(CodeMonoid, []Code{body, Line(fmt.Sprintf("    response_body: %q", rule.ResponseBody))})
+	}
+	return ct.Concat(CodeMonoid, []Code{Line("  option (google.api.http) = {"), body, Line("  };")})
+}
+
+// blockOptions renders option statements inside a message, enum, service
+// or RPC body, starting with deprecated.
+func blockOptions(deprecated bool, options []transformer.ProtoOption) Code {
+	return ct.FoldMap(transformer.WithDeprecated(deprecated, options), CodeMonoid, func(o transformer.ProtoOption) Code {
+		return Line(fmt.Sprintf("  option %s = %s;", o.Name, o.Value))
+	})
+}
+
+// compactOptions ends a field or enum value declaration with its
+// bracketed options, starting with deprecated. Like buf format, a single
+// option stays on the line and several go on lines of their own.
+func compactOptions(decl string, deprecated bool, options []transformer.ProtoOption) Code {
+	options = transformer.WithDeprecated(deprecated, options)
+	switch len(options) {
+	case 0:
+		return Line(decl + ";")
+	case 1:
+		return Line(fmt.Sprintf("%s [%s = %s];", decl, options[0].Name, options[0].Value))
+	}
+	code := Line(decl + " [")
+	for i, o := range options {
+		sep := ","
+		if i == len(options)-1 {
+			sep = ""
+		}
+		code = CodeMonoid.Append(code, Line(fmt.Sprintf("  %s = %s%s", o.Name, o.Value, sep)))
+	}
+	return CodeMonoid.Append(code, Line("];"))
 }
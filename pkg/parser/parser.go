@@ -3,8 +3,10 @@ package parser
 
 import (
 	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
+	"slices"
 	"strings"
 
 	"golang.org/x/tools/go/packages"
@@ -18,6 +20,7 @@ type GoPackage struct {
 	Interfaces []GoInterface
 	Aliases    []GoAlias
 	Consts     []GoConstGroup
+	Doc        []string // Package doc comment
 }
 
 // GoStruct represents a Go struct type.
@@ -27,16 +30,20 @@ type GoStruct struct {
 	Comments   []string
 	Tags       map[string]string
 	TypeParams []string // Generic type parameters (e.g., ["T", "K", "V"])
+	Deprecated string   // The "Deprecated:" doc paragraph, if any
+	Pos        token.Position
 }
 
 // GoField represents a struct field.
 type GoField struct {
-	Name     string
-	Type     GoType
-	Tag      string
-	Embedded bool
-	Comments []string
-	Exported bool
+	Name       string
+	Type       GoType
+	Tag        string
+	Embedded   bool
+	Comments   []string
+	Tags       map[string]string
+	Exported   bool
+	Deprecated string
 }
 
 // GoType represents a Go type.
@@ -54,8 +61,11 @@ type (
 		Elem GoType
 		Len  int64
 	}
-	MapType       struct{ Key, Value GoType }
-	NamedType     struct{ Package, Name string }
+	MapType   struct{ Key, Value GoType }
+	NamedType struct {
+		Package, Name string
+		TypeArgs      []GoType // Instantiated type arguments (e.g., iter.Seq[T])
+	}
 	InterfaceType struct{ Methods []GoMethod }
 	StructType    struct{ Fields []GoField }
 	ChanType      struct {
@@ -82,10 +92,18 @@ func (t SliceType) String() string   { return "[]" + t.Elem.String() }
 func (t ArrayType) String() string   { return "[]" + t.Elem.String() }
 func (t MapType) String() string     { return "map[" + t.Key.String() + "]" + t.Value.String() }
 func (t NamedType) String() string {
+	name := t.Name
 	if t.Package != "" {
-		return t.Package + "." + t.Name
+		name = t.Package + "." + t.Name
 	}
-	return t.Name
+	if len(t.TypeArgs) > 0 {
+		args := make([]string, len(t.TypeArgs))
+		for i, arg := range t.TypeArgs {
+			args[i] = arg.String()
+		}
+		name += "[" + strings.Join(args, ", ") + "]"
+	}
+	return name
 }
 func (t InterfaceType) String() string { return "interface{}" }
 func (t StructType) String() string    { return "struct{}" }
@@ -94,23 +112,31 @@ func (t FuncType) String() string      { return "func()" }
 
 // GoInterface represents a Go interface type.
 type GoInterface struct {
-	Name     string
-	Methods  []GoMethod
-	Comments []string
-	Tags     map[string]string
+	Name       string
+	Methods    []GoMethod
+	Comments   []string
+	Tags       map[string]string
+	Deprecated string
+	Pos        token.Position
 }
 
 // GoMethod represents a method.
 type GoMethod struct {
-	Name    string
-	Params  []GoParam
-	Results []GoParam
+	Name       string
+	Params     []GoParam
+	Results    []GoParam
+	Comments   []string
+	Tags       map[string]string
+	Deprecated string
+	Pos        token.Position
 }
 
 // GoParam represents a function parameter.
 type GoParam struct {
-	Name string
-	Type GoType
+	Name     string
+	Type     GoType // Element type for a variadic parameter
+	Variadic bool
+	IsFunc   bool // Type is a function type, possibly named (e.g., type Option func(*config))
 }
 
 // GoAlias represents a type alias.
@@ -119,19 +145,27 @@ type GoAlias struct {
 	Underlying GoType
 	Comments   []string
 	Tags       map[string]string
+	Deprecated string
+	Pos        token.Position
 }
 
 // GoConstGroup represents constants for enum detection.
 type GoConstGroup struct {
 	TypeName string
 	Values   []GoConstValue
+	Pos      token.Position // First constant of the type
 }
 
 // GoConstValue represents a constant value.
 type GoConstValue struct {
-	Name     string
-	Value    int64
-	Comments []string
+	Name  string
+	Value int64
+	// Overflow is set when the value does not fit in an int64, such as a
+	// uint64 constant with the high bit set. Value is then 0.
+	Overflow   bool
+	Comments   []string
+	Tags       map[string]string
+	Deprecated string
 }
 
 // Parser extracts Go types from packages.
@@ -178,8 +212,19 @@ func (p *Parser) extractPackage(pkg *packages.Package) GoPackage {
 	}
 
 	constGroups := make(map[string]*GoConstGroup)
-
-	for _, file := range pkg.Syntax {
+	var constOrder []string
+
+	// Visit files by name so declarations come out in source order no
+	// matter how the loader returned them.
+	files := slices.Clone(pkg.Syntax)
+	slices.SortFunc(files, func(a, b *ast.File) int {
+		return strings.Compare(p.fset.Position(a.Package).Filename, p.fset.Position(b.Package).Filename)
+	})
+
+	for _, file := range files {
+		if goPkg.Doc == nil {
+			goPkg.Doc = extractComments(file.Doc)
+		}
 		for _, decl := range file.Decls {
 			switch d := decl.(type) {
 			case *ast.GenDecl:
@@ -194,29 +239,36 @@ func (p *Parser) extractPackage(pkg *packages.Package) GoPackage {
 							continue
 						}
 
+						pos := p.fset.Position(ts.Pos())
 						switch t := ts.Type.(type) {
 						case *ast.StructType:
-							goPkg.Structs = append(goPkg.Structs, p.extractStruct(ts.Name.Name, t, comments, tags, pkg, ts.TypeParams))
+							s := p.extractStruct(ts.Name.Name, t, comments, tags, pkg, ts.TypeParams)
+							s.Pos = pos
+							goPkg.Structs = append(goPkg.Structs, s)
 						case *ast.InterfaceType:
-							goPkg.Interfaces = append(goPkg.Interfaces, p.extractInterface(ts.Name.Name, t, comments, tags, pkg))
+							iface := p.extractInterface(ts.Name.Name, t, comments, tags, pkg)
+							iface.Pos = pos
+							goPkg.Interfaces = append(goPkg.Interfaces, iface)
 						case *ast.Ident, *ast.SelectorExpr:
 							goPkg.Aliases = append(goPkg.Aliases, GoAlias{
 								Name:       ts.Name.Name,
 								Underlying: p.extractType(ts.Type, pkg),
 								Comments:   comments,
 								Tags:       tags,
+								Deprecated: deprecationNotice(comments),
+								Pos:        pos,
 							})
 						}
 					}
 				case token.CONST:
-					p.extractConsts(d, constGroups, pkg)
+					constOrder = p.extractConsts(d, constGroups, constOrder, pkg)
 				}
 			}
 		}
 	}
 
-	for _, cg := range constGroups {
-		if len(cg.Values) > 0 {
+	for _, name := range constOrder {
+		if cg := constGroups[name]; len(cg.Values) > 0 {
 			goPkg.Consts = append(goPkg.Consts, *cg)
 		}
 	}
@@ -224,7 +276,7 @@ func (p *Parser) extractPackage(pkg *packages.Package) GoPackage {
 }
 
 func (p *Parser) extractStruct(name string, st *ast.StructType, comments []string, tags map[string]string, pkg *packages.Package, typeParams *ast.FieldList) GoStruct {
-	s := GoStruct{Name: name, Comments: comments, Tags: tags}
+	s := GoStruct{Name: name, Comments: comments, Tags: tags, Deprecated: deprecationNotice(comments)}
 
 	// Extract type parameter names
 	if typeParams != nil {
@@ -247,6 +299,8 @@ func (p *Parser) extractField(field *ast.Field, pkg *packages.Package) []GoField
 	var fields []GoField
 	fieldType := p.extractType(field.Type, pkg)
 	comments := extractComments(field.Doc)
+	tags := extractTags(comments)
+	deprecated := deprecationNotice(comments)
 	tag := ""
 	if field.Tag != nil {
 		tag = field.Tag.Value
@@ -255,13 +309,14 @@ func (p *Parser) extractField(field *ast.Field, pkg *packages.Package) []GoField
 	if len(field.Names) == 0 {
 		fields = append(fields, GoField{
 			Name: typeNameFromGoType(fieldType), Type: fieldType, Tag: tag,
-			Embedded: true, Comments: comments, Exported: true,
+			Embedded: true, Comments: comments, Tags: tags, Exported: true, Deprecated: deprecated,
 		})
 	} else {
 		for _, name := range field.Names {
 			fields = append(fields, GoField{
 				Name: name.Name, Type: fieldType, Tag: tag,
-				Embedded: false, Comments: comments, Exported: ast.IsExported(name.Name),
+				Embedded: false, Comments: comments, Tags: tags, Exported: ast.IsExported(name.Name),
+				Deprecated: deprecated,
 			})
 		}
 	}
@@ -269,17 +324,22 @@ func (p *Parser) extractField(field *ast.Field, pkg *packages.Package) []GoField
 }
 
 func (p *Parser) extractInterface(name string, it *ast.InterfaceType, comments []string, tags map[string]string, pkg *packages.Package) GoInterface {
-	iface := GoInterface{Name: name, Comments: comments, Tags: tags}
+	iface := GoInterface{Name: name, Comments: comments, Tags: tags, Deprecated: deprecationNotice(comments)}
 	if it.Methods != nil {
 		for _, m := range it.Methods.List {
 			if len(m.Names) == 0 {
 				continue
 			}
 			if ft, ok := m.Type.(*ast.FuncType); ok {
+				methodComments := extractComments(m.Doc)
 				iface.Methods = append(iface.Methods, GoMethod{
-					Name:    m.Names[0].Name,
-					Params:  p.extractParams(ft.Params, pkg),
-					Results: p.extractParams(ft.Results, pkg),
+					Name:       m.Names[0].Name,
+					Params:     p.extractParams(ft.Params, pkg),
+					Results:    p.extractParams(ft.Results, pkg),
+					Comments:   methodComments,
+					Tags:       extractTags(methodComments),
+					Deprecated: deprecationNotice(methodComments),
+					Pos:        p.fset.Position(m.Pos()),
 				})
 			}
 		}
@@ -293,12 +353,17 @@ func (p *Parser) extractParams(fl *ast.FieldList, pkg *packages.Package) []GoPar
 	}
 	var params []GoParam
 	for _, field := range fl.List {
-		paramType := p.extractType(field.Type, pkg)
+		typeExpr, variadic := field.Type, false
+		if ellipsis, ok := typeExpr.(*ast.Ellipsis); ok {
+			typeExpr, variadic = ellipsis.Elt, true
+		}
+		paramType := p.extractType(typeExpr, pkg)
+		isFunc := isFuncType(typeExpr, pkg)
 		if len(field.Names) == 0 {
-			params = append(params, GoParam{Type: paramType})
+			params = append(params, GoParam{Type: paramType, Variadic: variadic, IsFunc: isFunc})
 		} else {
 			for _, name := range field.Names {
-				params = append(params, GoParam{Name: name.Name, Type: paramType})
+				params = append(params, GoParam{Name: name.Name, Type: paramType, Variadic: variadic, IsFunc: isFunc})
 			}
 		}
 	}
@@ -327,6 +392,10 @@ func (p *Parser) extractType(expr ast.Expr, pkg *packages.Package) GoType {
 		return NamedType{Name: t.Sel.Name}
 	case *ast.StarExpr:
 		return PointerType{Elem: p.extractType(t.X, pkg)}
+	case *ast.IndexExpr:
+		return p.instantiate(t.X, []ast.Expr{t.Index}, pkg)
+	case *ast.IndexListExpr:
+		return p.instantiate(t.X, t.Indices, pkg)
 	case *ast.ArrayType:
 		elem := p.extractType(t.Elt, pkg)
 		if t.Len == nil {
@@ -348,7 +417,38 @@ func (p *Parser) extractType(expr ast.Expr, pkg *packages.Package) GoType {
 	}
 }
 
-func (p *Parser) extractConsts(gd *ast.GenDecl, groups map[string]*GoConstGroup, pkg *packages.Package) {
+// isFuncType reports whether a type expression denotes a function type,
+// resolving named types through the type checker when available.
+func isFuncType(expr ast.Expr, pkg *packages.Package) bool {
+	if _, ok := expr.(*ast.FuncType); ok {
+		return true
+	}
+	if pkg == nil || pkg.TypesInfo == nil {
+		return false
+	}
+	t := pkg.TypesInfo.TypeOf(expr)
+	if t == nil {
+		return false
+	}
+	_, ok := t.Underlying().(*types.Signature)
+	return ok
+}
+
+// instantiate extracts a generic type instantiation such as iter.Seq[T].
+func (p *Parser) instantiate(base ast.Expr, args []ast.Expr, pkg *packages.Package) GoType {
+	named, ok := p.extractType(base, pkg).(NamedType)
+	if !ok {
+		return BasicType{Name: "any"}
+	}
+	for _, arg := range args {
+		named.TypeArgs = append(named.TypeArgs, p.extractType(arg, pkg))
+	}
+	return named
+}
+
+// extractConsts adds the typed constants of a declaration to their groups
+// and returns order extended with the type names seen for the first time.
+func (p *Parser) extractConsts(gd *ast.GenDecl, groups map[string]*GoConstGroup, order []string, pkg *packages.Package) []string {
 	var currentType string
 	var iotaValue int64 = 0
 
@@ -374,8 +474,9 @@ func (p *Parser) extractConsts(gd *ast.GenDecl, groups map[string]*GoConstGroup,
 
 		group, ok := groups[currentType]
 		if !ok {
-			group = &GoConstGroup{TypeName: currentType}
+			group = &GoConstGroup{TypeName: currentType, Pos: p.fset.Position(vs.Pos())}
 			groups[currentType] = group
+			order = append(order, currentType)
 		}
 
 		for _, name := range vs.Names {
@@ -384,28 +485,152 @@ func (p *Parser) extractConsts(gd *ast.GenDecl, groups map[string]*GoConstGroup,
 				continue
 			}
 
+			comments := extractComments(vs.Doc)
+			value, overflow := constValue(name, pkg, iotaValue)
 			group.Values = append(group.Values, GoConstValue{
-				Name: name.Name, Value: iotaValue, Comments: extractComments(vs.Doc),
+				Name: name.Name, Value: value, Overflow: overflow, Comments: comments,
+				Tags: extractTags(comments), Deprecated: deprecationNotice(comments),
 			})
 			iotaValue++
 		}
 	}
+	return order
 }
 
+// constValue returns the value the type checker computed for a constant,
+// falling back to the iota position when type information is unavailable.
+// It reports whether the value overflows an int64.
+func constValue(name *ast.Ident, pkg *packages.Package, fallback int64) (int64, bool) {
+	if pkg == nil || pkg.TypesInfo == nil {
+		return fallback, false
+	}
+	c, ok := pkg.TypesInfo.Defs[name].(*types.Const)
+	if !ok {
+		return fallback, false
+	}
+	v := constant.ToInt(c.Val())
+	if v.Kind() != constant.Int {
+		return fallback, false
+	}
+	if n, exact := constant.Int64Val(v); exact {
+		return n, false
+	}
+	return 0, true
+}
+
+// extractComments returns the lines of a doc comment. Blank lines separate
+// paragraphs and indented lines keep their indentation, as in go/doc.
+// Tool directives such as //go:generate are left out.
 func extractComments(cg *ast.CommentGroup) []string {
 	if cg == nil {
 		return nil
 	}
 	var comments []string
 	for _, c := range cg.List {
-		text := strings.TrimPrefix(c.Text, "//")
-		text = strings.TrimPrefix(text, "/*")
-		text = strings.TrimSuffix(text, "*/")
-		comments = append(comments, strings.TrimSpace(text))
+		text, ok := strings.CutPrefix(c.Text, "//")
+		if !ok {
+			comments = append(comments, blockCommentLines(c.Text)...)
+			continue
+		}
+		if isDirective(text) {
+			continue
+		}
+		comments = append(comments, strings.TrimRight(strings.TrimPrefix(text, " "), " \t"))
 	}
 	return comments
 }
 
+// blockCommentLines splits a /* */ comment into lines, removing the
+// indentation and leading " * " decoration shared by its lines.
+func blockCommentLines(text string) []string {
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	lines[0] = strings.TrimSpace(lines[0])
+
+	rest := lines[1:]
+	decorated := true
+	for _, line := range rest {
+		if line != "" && !strings.HasPrefix(strings.TrimSpace(line), "*") {
+			decorated = false
+		}
+	}
+	if decorated {
+		for i, line := range rest {
+			line = strings.TrimPrefix(strings.TrimSpace(line), "*")
+			rest[i] = strings.TrimPrefix(line, " ")
+		}
+	} else {
+		prefix, first := "", true
+		for _, line := range rest {
+			if line == "" {
+				continue
+			}
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			if first {
+				prefix, first = indent, false
+			}
+			for !strings.HasPrefix(indent, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+		for i, line := range rest {
+			rest[i] = strings.TrimPrefix(line, prefix)
+		}
+	}
+
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// isDirective reports whether a // comment is a tool directive rather
+// than documentation, using the same rule as go/ast.
+func isDirective(text string) bool {
+	for _, prefix := range []string{"line ", "extern ", "export "} {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	name, _, ok := strings.Cut(text, ":")
+	if !ok || name == "" || len(text) == len(name)+1 {
+		return false
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	next := text[len(name)+1]
+	return 'a' <= next && next <= 'z' || '0' <= next && next <= '9'
+}
+
+// deprecationNotice returns the "Deprecated:" paragraph of a doc comment,
+// following the Go convention, or "" if there is none.
+func deprecationNotice(comments []string) string {
+	var notice []string
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case notice == nil && strings.HasPrefix(line, "Deprecated:"):
+				notice = append(notice, line)
+			case notice != nil && line == "":
+				return strings.Join(notice, " ")
+			case notice != nil:
+				notice = append(notice, line)
+			}
+		}
+	}
+	return strings.Join(notice, " ")
+}
+
 func extractTags(comments []string) map[string]string {
 	tags := make(map[string]string)
 	for _, line := range comments {
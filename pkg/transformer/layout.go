@@ -0,0 +1,344 @@
+package transformer
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/ct"
+	"github.com/vinodhalaharvi/go2proto/pkg/parser"
+)
+
+// Layout selects how Go packages are divided into files and where each
+// file is written, relative to the output directory.
+type Layout string
+
+const (
+	// LayoutFlat writes <package name>.proto into the output directory.
+	LayoutFlat Layout = "flat"
+	// LayoutPackage writes each file into the directory of its proto
+	// package, named after the last segment that is not a version, e.g.
+	// acme/users/v1/users.proto for acme.users.v1.
+	LayoutPackage Layout = "package"
+	// LayoutFile writes one file per Go source file into the directory of
+	// its proto package, e.g. acme/users/v1/user.proto for user.go.
+	LayoutFile Layout = "file"
+)
+
+// PackageFile is the part of a Go package written to one .proto file.
+type PackageFile struct {
+	// Name is the slash-separated path of the file, relative to the
+	// output directory.
+	Name    string
+	Package parser.GoPackage
+	// Decls holds the declarations of Package written to this file.
+	Decls parser.GoPackage
+}
+
+// Files divides pkg into the files it is written to under layout.
+// Under LayoutFile, Go files whose declarations reference each other in
+// a cycle would import each other, which protoc rejects. They are merged
+// into one file with MergeImportCycles, and are an error otherwise.
+func (t *Transformer) Files(pkg parser.GoPackage, layout Layout) ([]PackageFile, []Diagnostic, error) {
+	if layout != LayoutFile {
+		return []PackageFile{{Name: t.filePath(pkg, layout), Package: pkg, Decls: pkg}}, nil, nil
+	}
+
+	dir := path.Dir(t.filePath(pkg, LayoutPackage))
+	files := t.splitFiles(pkg, dir)
+
+	// Transform each file once to find what it references.
+	t.symbols = make(map[string]bool)
+	t.declareSymbols(pkg)
+	declaredIn := make(map[string]string)
+	protos := make([]Proto, len(files))
+	for i, f := range files {
+		protos[i] = t.transformDecls(pkg, f.Decls)
+		for _, name := range topLevelNames(protos[i]) {
+			declaredIn[name] = f.Name
+		}
+	}
+	var kept []PackageFile
+	imports := make(map[string][]string)
+	for i, f := range files {
+		p := protos[i]
+		if len(p.Messages) == 0 && len(p.Enums) == 0 && len(p.Services) == 0 {
+			continue
+		}
+		kept = append(kept, f)
+		for _, name := range references(p) {
+			if file, ok := declaredIn[name]; ok && file != f.Name {
+				imports[f.Name] = append(imports[f.Name], file)
+			}
+		}
+	}
+
+	var diags []Diagnostic
+	var errs []error
+	for _, cycle := range importCycles(kept, imports) {
+		names := make([]string, len(cycle))
+		for i, f := range cycle {
+			names[i] = path.Base(f.Name)
+		}
+		if !t.opts.MergeImportCycles {
+			errs = append(errs, fmt.Errorf("package %s: %s would import each other; move the types into one Go file or use -merge-cycles",
+				pkg.Path, strings.Join(names, ", ")))
+			continue
+		}
+		kept = mergeFiles(kept, cycle)
+		diags = append(diags, Diagnostic{
+			Severity: SeverityInfo, Element: "package " + pkg.Path,
+			Message: fmt.Sprintf("merged %s into %s to break an import cycle", strings.Join(names, ", "), names[0]),
+		})
+	}
+	if len(errs) > 0 {
+		return nil, diags, errors.Join(errs...)
+	}
+	if len(kept) > 0 {
+		kept[0].Decls.Doc = pkg.Doc
+	}
+	return kept, diags, nil
+}
+
+// splitFiles gives each Go source file of pkg its own file in dir. Enums
+// are placed with their type declaration; aliases are kept in every file
+// so enums declared apart from their constants keep their comments.
+func (t *Transformer) splitFiles(pkg parser.GoPackage, dir string) []PackageFile {
+	fileOf := func(pos string) string {
+		return path.Join(dir, strings.TrimSuffix(filepath.Base(pos), ".go")+".proto")
+	}
+	byName := make(map[string]*PackageFile)
+	part := func(name string) *PackageFile {
+		if f, ok := byName[name]; ok {
+			return f
+		}
+		f := &PackageFile{Name: name, Package: pkg, Decls: parser.GoPackage{
+			Name: pkg.Name, Path: pkg.Path, Aliases: pkg.Aliases,
+		}}
+		byName[name] = f
+		return f
+	}
+	for _, s := range pkg.Structs {
+		f := part(fileOf(s.Pos.Filename))
+		f.Decls.Structs = append(f.Decls.Structs, s)
+	}
+	for _, i := range pkg.Interfaces {
+		f := part(fileOf(i.Pos.Filename))
+		f.Decls.Interfaces = append(f.Decls.Interfaces, i)
+	}
+	aliases := aliasLookup(pkg)
+	for _, cg := range pkg.Consts {
+		f := part(fileOf(enumPos(cg, aliases[cg.TypeName]).Filename))
+		f.Decls.Consts = append(f.Decls.Consts, cg)
+	}
+
+	files := make([]PackageFile, 0, len(byName))
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		files = append(files, *byName[name])
+	}
+	return files
+}
+
+// TransformFiles converts the files of one package, as returned by Files.
+// Each file imports the files it references types from, which Link must
+// have been given.
+func (t *Transformer) TransformFiles(files []PackageFile) []Proto {
+	if len(files) == 0 {
+		return nil
+	}
+	t.symbols = make(map[string]bool)
+	t.declareSymbols(files[0].Package)
+	protos := make([]Proto, len(files))
+	for i, f := range files {
+		protos[i] = t.transformDecls(f.Package, f.Decls)
+	}
+
+	pkg := ct.Concat(ProtoMonoid, protos)
+	for i, f := range files {
+		p := protos[i]
+		p.Diagnostics = append(p.Diagnostics, checkServices(p, pkg)...)
+		for _, name := range references(p) {
+			if mapping, ok := t.opts.TypeMappings[f.Package.Path+"."+name]; ok && mapping.Import != "" && mapping.Import != f.Name {
+				p.Imports = append(p.Imports, mapping.Import)
+			}
+		}
+		p.Imports = ct.Unique(p.Imports)
+		protos[i] = orderDeclarations(p, t.opts.Ordering)
+	}
+	return protos
+}
+
+// filePath returns the path of the single file pkg is written to under
+// layout.
+func (t *Transformer) filePath(pkg parser.GoPackage, layout Layout) string {
+	if layout != LayoutPackage {
+		return pkg.Name + ".proto"
+	}
+	segments := strings.Split(t.protoPackage(pkg), ".")
+	name := pkg.Name
+	for i := len(segments) - 1; i >= 0; i-- {
+		if !versionSegment.MatchString(segments[i]) {
+			name = segments[i]
+			break
+		}
+	}
+	return path.Join(append(segments, name+".proto")...)
+}
+
+// Link makes references to the types declared in files resolve to the
+// files they are written to. From other packages they would otherwise be
+// google.protobuf.Any. Messages and enums are referenced by their fully
+// qualified name and imported by their path relative to the output
+// directory; bit-flag types keep their integer type.
+func (t *Transformer) Link(files []PackageFile) {
+	mappings := maps.Clone(t.opts.TypeMappings)
+	if mappings == nil {
+		mappings = make(map[string]TypeMapping)
+	}
+	for _, f := range files {
+		for name, mapping := range t.exports(f) {
+			mappings[f.Package.Path+"."+name] = mapping
+		}
+	}
+	t.opts.TypeMappings = mappings
+}
+
+// exports maps the names of the types declared in f to how other files
+// refer to them.
+func (t *Transformer) exports(f PackageFile) map[string]TypeMapping {
+	protoPackage := t.protoPackage(f.Package)
+	exports := make(map[string]TypeMapping)
+	declare := func(name string) {
+		exports[name] = TypeMapping{Proto: protoPackage + "." + name, Import: f.Name}
+	}
+
+	flags := t.buildFlagLookup(f.Package)
+	saved := t.flags
+	t.flags = flags
+	enums := t.buildEnumLookup(f.Package)
+	t.flags = saved
+
+	for _, cg := range f.Decls.Consts {
+		if flag, ok := flags[cg.TypeName]; ok {
+			exports[cg.TypeName] = TypeMapping{Proto: flag.proto}
+		} else if enums[cg.TypeName] {
+			declare(cg.TypeName)
+		}
+	}
+	for _, s := range f.Decls.Structs {
+		if s.Tags["go2proto"] != "false" && len(s.Name) > 0 && unicode.IsUpper(rune(s.Name[0])) {
+			declare(s.Name)
+		}
+	}
+	return exports
+}
+
+// topLevelNames lists the enums and messages p declares.
+func topLevelNames(p Proto) []string {
+	var names []string
+	for _, en := range p.Enums {
+		names = append(names, en.Name)
+	}
+	for _, msg := range p.Messages {
+		names = append(names, msg.Name)
+	}
+	return names
+}
+
+// references lists the top-level names the messages and services of p
+// refer to.
+func references(p Proto) []string {
+	var refs []string
+	for _, msg := range p.Messages {
+		refs = append(refs, messageDeps(msg)...)
+	}
+	for _, s := range p.Services {
+		for _, rpc := range s.Methods {
+			refs = append(refs, rpc.InputType, rpc.OutputType)
+		}
+	}
+	return ct.Unique(refs)
+}
+
+// importCycles returns the groups of files that import each other,
+// directly or through other files, each in file order.
+func importCycles(files []PackageFile, imports map[string][]string) [][]PackageFile {
+	// Tarjan's strongly connected components.
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var components [][]string
+	var visit func(name string)
+	visit = func(name string) {
+		index[name] = len(index)
+		low[name] = index[name]
+		stack = append(stack, name)
+		onStack[name] = true
+		for _, dep := range imports[name] {
+			if _, seen := index[dep]; !seen {
+				visit(dep)
+				low[name] = min(low[name], low[dep])
+			} else if onStack[dep] {
+				low[name] = min(low[name], index[dep])
+			}
+		}
+		if low[name] != index[name] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == name {
+				break
+			}
+		}
+		if len(component) > 1 {
+			components = append(components, component)
+		}
+	}
+	for _, f := range files {
+		if _, seen := index[f.Name]; !seen {
+			visit(f.Name)
+		}
+	}
+
+	var cycles [][]PackageFile
+	for _, component := range components {
+		cycles = append(cycles, ct.Filter(files, func(f PackageFile) bool {
+			return slices.Contains(component, f.Name)
+		}))
+	}
+	slices.SortFunc(cycles, func(a, b []PackageFile) int { return strings.Compare(a[0].Name, b[0].Name) })
+	return cycles
+}
+
+// mergeFiles moves the declarations of cycle into its first file.
+func mergeFiles(files []PackageFile, cycle []PackageFile) []PackageFile {
+	into := cycle[0].Name
+	merged := make([]PackageFile, 0, len(files))
+	var target *PackageFile
+	for _, f := range files {
+		if !slices.ContainsFunc(cycle, func(c PackageFile) bool { return c.Name == f.Name }) {
+			merged = append(merged, f)
+			continue
+		}
+		if f.Name == into {
+			merged = append(merged, f)
+			target = &merged[len(merged)-1]
+			continue
+		}
+		target.Decls.Structs = append(target.Decls.Structs, f.Decls.Structs...)
+		target.Decls.Interfaces = append(target.Decls.Interfaces, f.Decls.Interfaces...)
+		target.Decls.Consts = append(target.Decls.Consts, f.Decls.Consts...)
+	}
+	return merged
+}
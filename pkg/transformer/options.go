@@ -0,0 +1,130 @@
+package transformer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// OptionKind is the type of an option value.
+type OptionKind int
+
+const (
+	OptionString OptionKind = iota
+	OptionBool
+	OptionInt
+	OptionIdent // Enum value identifier, e.g. SPEED
+)
+
+// OptionValue is a typed option value.
+type OptionValue struct {
+	Kind  OptionKind
+	Value string
+}
+
+// StringOption returns a string option value.
+func StringOption(s string) OptionValue { return OptionValue{Kind: OptionString, Value: s} }
+
+// BoolOption returns a bool option value.
+func BoolOption(b bool) OptionValue {
+	return OptionValue{Kind: OptionBool, Value: strconv.FormatBool(b)}
+}
+
+// IdentOption returns an enum identifier option value.
+func IdentOption(id string) OptionValue { return OptionValue{Kind: OptionIdent, Value: id} }
+
+// String renders the value in .proto syntax.
+func (v OptionValue) String() string {
+	if v.Kind == OptionString {
+		return strconv.Quote(v.Value)
+	}
+	return v.Value
+}
+
+var (
+	intLiteral   = regexp.MustCompile(`^-?[0-9]+$`)
+	enumLiteral  = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+	quotedString = regexp.MustCompile(`^"(.*)"$`)
+)
+
+// ParseOptionValue infers the type of an option value written in
+// configuration: true and false are bools, integers are ints, upper-case
+// identifiers are enum values and anything else, or anything in double
+// quotes, is a string.
+func ParseOptionValue(s string) OptionValue {
+	switch {
+	case quotedString.MatchString(s):
+		return StringOption(quotedString.FindStringSubmatch(s)[1])
+	case s == "true" || s == "false":
+		return BoolOption(s == "true")
+	case intLiteral.MatchString(s):
+		return OptionValue{Kind: OptionInt, Value: s}
+	case enumLiteral.MatchString(s):
+		return IdentOption(s)
+	}
+	return StringOption(s)
+}
+
+// WithDeprecated prepends deprecated = true to options when set.
+func WithDeprecated(deprecated bool, options []ProtoOption) []ProtoOption {
+	if !deprecated {
+		return options
+	}
+	return append([]ProtoOption{{Name: "deprecated", Value: BoolOption(true)}}, options...)
+}
+
+var versionSegment = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]*)?$`)
+
+// managedOptions derives the language options buf's managed mode would set
+// for a proto package, e.g. acme.users.v1 gives java_package
+// "com.acme.users.v1" and csharp_namespace "Acme.Users.V1".
+func managedOptions(protoPackage string) map[string]OptionValue {
+	if protoPackage == "" {
+		return nil
+	}
+	segments := strings.Split(protoPackage, ".")
+	pascal := make([]string, len(segments))
+	var prefix strings.Builder
+	for i, seg := range segments {
+		pascal[i] = toPascalCase(seg)
+		if !versionSegment.MatchString(seg) && seg != "" {
+			prefix.WriteRune(unicode.ToUpper(rune(seg[0])))
+		}
+	}
+	// Prefixes of fewer than three letters are reserved by Apple, so they
+	// are padded with X.
+	objcPrefix := prefix.String()
+	if len(objcPrefix) < 3 {
+		objcPrefix += strings.Repeat("X", 3-len(objcPrefix))
+	}
+	if objcPrefix == "GPB" {
+		// GPB is reserved for the protobuf runtime.
+		objcPrefix = "GPX"
+	}
+	return map[string]OptionValue{
+		"java_package":        StringOption("com." + protoPackage),
+		"java_multiple_files": BoolOption(true),
+		"csharp_namespace":    StringOption(strings.Join(pascal, ".")),
+		"objc_class_prefix":   StringOption(objcPrefix),
+		"php_namespace":       StringOption(strings.Join(pascal, `\`)),
+		"ruby_package":        StringOption(strings.Join(pascal, "::")),
+	}
+}
+
+func toPascalCase(s string) string {
+	var result strings.Builder
+	upper := true
+	for _, r := range s {
+		if r == '_' || r == '-' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
@@ -0,0 +1,107 @@
+package transformer
+
+import (
+	"cmp"
+	"go/token"
+	"slices"
+	"strings"
+)
+
+// Ordering selects the order of top-level enums, messages and services in
+// the generated file. Every ordering is deterministic.
+type Ordering string
+
+const (
+	// OrderingSource follows the Go declarations, by file name and then
+	// line. Synthesized RPC messages sit at the method they belong to.
+	OrderingSource Ordering = "source"
+	// OrderingAlphabetical sorts declarations by name.
+	OrderingAlphabetical Ordering = "alphabetical"
+	// OrderingTopological places each message after the messages its
+	// fields reference, breaking cycles in source order. Enums and
+	// services keep source order.
+	OrderingTopological Ordering = "topological"
+)
+
+// orderDeclarations sorts the top-level declarations of p.
+func orderDeclarations(p Proto, ordering Ordering) Proto {
+	p.Enums = slices.Clone(p.Enums)
+	p.Messages = slices.Clone(p.Messages)
+	p.Services = slices.Clone(p.Services)
+
+	if ordering == OrderingAlphabetical {
+		slices.SortStableFunc(p.Enums, func(a, b ProtoEnum) int { return strings.Compare(a.Name, b.Name) })
+		slices.SortStableFunc(p.Messages, func(a, b ProtoMessage) int { return strings.Compare(a.Name, b.Name) })
+		slices.SortStableFunc(p.Services, func(a, b ProtoService) int { return strings.Compare(a.Name, b.Name) })
+		return p
+	}
+
+	slices.SortStableFunc(p.Enums, func(a, b ProtoEnum) int { return comparePos(a.Pos, b.Pos) })
+	slices.SortStableFunc(p.Messages, func(a, b ProtoMessage) int { return comparePos(a.Pos, b.Pos) })
+	slices.SortStableFunc(p.Services, func(a, b ProtoService) int { return comparePos(a.Pos, b.Pos) })
+	if ordering == OrderingTopological {
+		p.Messages = topologicalMessages(p.Messages)
+	}
+	return p
+}
+
+func comparePos(a, b token.Position) int {
+	return cmp.Or(
+		strings.Compare(a.Filename, b.Filename),
+		cmp.Compare(a.Line, b.Line),
+		cmp.Compare(a.Column, b.Column),
+	)
+}
+
+// topologicalMessages orders messages so that each follows the messages
+// its fields reference. Ties and cycles keep the incoming order.
+func topologicalMessages(messages []ProtoMessage) []ProtoMessage {
+	index := make(map[string]int, len(messages))
+	for i, msg := range messages {
+		if _, ok := index[msg.Name]; !ok {
+			index[msg.Name] = i
+		}
+	}
+
+	visited := make([]bool, len(messages))
+	ordered := make([]ProtoMessage, 0, len(messages))
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		for _, dep := range messageDeps(messages[i]) {
+			if j, ok := index[dep]; ok {
+				visit(j)
+			}
+		}
+		ordered = append(ordered, messages[i])
+	}
+	for i := range messages {
+		visit(i)
+	}
+	return ordered
+}
+
+// messageDeps returns the top-level names referenced by the fields of a
+// message and its nested messages, in field order.
+func messageDeps(msg ProtoMessage) []string {
+	var deps []string
+	for _, f := range msg.Fields {
+		for _, typ := range []string{f.Type, f.MapValue} {
+			if typ == "" {
+				continue
+			}
+			// A nested reference such as User.Address depends on User.
+			name, _, _ := strings.Cut(typ, ".")
+			if name != msg.Name {
+				deps = append(deps, name)
+			}
+		}
+	}
+	for _, nested := range msg.Nested {
+		deps = append(deps, messageDeps(nested)...)
+	}
+	return deps
+}
@@ -0,0 +1,146 @@
+package transformer
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/parser"
+)
+
+// Syntax selects the .proto language the output targets.
+type Syntax string
+
+const (
+	SyntaxProto3 Syntax = "proto3"
+	// SyntaxProto2 labels every singular field: pointer fields, fields
+	// with a default and omitempty fields are optional, the rest required.
+	SyntaxProto2 Syntax = "proto2"
+	// SyntaxEditions writes edition = TransformOptions.Edition with
+	// proto3-like presence: implicit by default, explicit for pointer
+	// fields and fields with a default.
+	SyntaxEditions Syntax = "editions"
+)
+
+// DefaultEdition is the edition written when none is configured.
+const DefaultEdition = "2023"
+
+const fieldPresence = "features.field_presence"
+
+// applyPresence sets the label, presence feature and default value of a
+// singular field for the target syntax. Defaults come from a
+// default:"..." struct tag or the def= part of a protobuf tag.
+func (t *Transformer) applyPresence(field *ProtoField, f parser.GoField, enumLookup map[string]bool, element string) []Diagnostic {
+	singular := !field.Repeated && field.MapKey == "" && field.Oneof == ""
+	scalar := isBasicProtoType(field.Type) || enumLookup[field.Type]
+	_, pointer := f.Type.(parser.PointerType)
+	tag := reflect.StructTag(strings.Trim(f.Tag, "`"))
+
+	var diags []Diagnostic
+	warn := func(message string) {
+		diags = append(diags, Diagnostic{Severity: SeverityWarning, Element: element, Message: message + "; default dropped"})
+	}
+	value, hasDefault := tag.Lookup("default")
+	if field.Default != nil { // raw def= value from a protobuf tag
+		value, hasDefault = field.Default.Value, true
+		field.Default = nil
+	}
+	if hasDefault {
+		switch {
+		case t.opts.Syntax != SyntaxProto2 && t.opts.Syntax != SyntaxEditions:
+			warn("proto3 has no default values")
+		case !singular || !scalar:
+			warn("only singular scalar and enum fields can have a default")
+		default:
+			def, err := defaultValue(field.Type, value, enumLookup[field.Type])
+			if err != nil {
+				warn(err.Error())
+			} else {
+				field.Default = &def
+			}
+		}
+	}
+	if !singular {
+		return diags
+	}
+
+	omitempty := strings.Contains(tag.Get("json"), ",omitempty")
+	switch t.opts.Syntax {
+	case SyntaxProto2:
+		if !field.Required {
+			field.Optional = pointer || field.Optional || field.Default != nil || omitempty
+			field.Required = !field.Optional
+		}
+	case SyntaxEditions:
+		switch {
+		case field.Required:
+			field.Options = append([]ProtoOption{{Name: fieldPresence, Value: IdentOption("LEGACY_REQUIRED")}}, field.Options...)
+		case scalar && (field.Optional || field.Default != nil):
+			field.Options = append([]ProtoOption{{Name: fieldPresence, Value: IdentOption("EXPLICIT")}}, field.Options...)
+		}
+		field.Optional, field.Required = false, false
+	default:
+		field.Required = false
+	}
+	return diags
+}
+
+// defaultValue parses a Go default tag into a literal of the proto type.
+func defaultValue(protoType, value string, isEnum bool) (OptionValue, error) {
+	switch protoType {
+	case "string", "bytes":
+		return StringOption(value), nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return OptionValue{}, fmt.Errorf("invalid bool default %q", value)
+		}
+		return BoolOption(b), nil
+	case "int32", "int64", "sint32", "sint64", "sfixed32", "sfixed64":
+		n, err := strconv.ParseInt(value, 0, bitSize(protoType))
+		if err != nil {
+			return OptionValue{}, fmt.Errorf("invalid %s default %q", protoType, value)
+		}
+		return IdentOption(strconv.FormatInt(n, 10)), nil
+	case "uint32", "uint64", "fixed32", "fixed64":
+		n, err := strconv.ParseUint(value, 0, bitSize(protoType))
+		if err != nil {
+			return OptionValue{}, fmt.Errorf("invalid %s default %q", protoType, value)
+		}
+		return IdentOption(strconv.FormatUint(n, 10)), nil
+	case "float", "double":
+		f, err := strconv.ParseFloat(value, bitSize(protoType))
+		if err != nil {
+			return OptionValue{}, fmt.Errorf("invalid %s default %q", protoType, value)
+		}
+		switch {
+		case math.IsNaN(f):
+			return IdentOption("nan"), nil
+		case math.IsInf(f, 1):
+			return IdentOption("inf"), nil
+		case math.IsInf(f, -1):
+			return IdentOption("-inf"), nil
+		}
+		return IdentOption(strconv.FormatFloat(f, 'g', -1, bitSize(protoType))), nil
+	}
+	if !isEnum {
+		return OptionValue{}, fmt.Errorf("type %s cannot have a default", protoType)
+	}
+	if enumLiteral.MatchString(value) {
+		return IdentOption(value), nil
+	}
+	if intLiteral.MatchString(value) {
+		return OptionValue{}, fmt.Errorf("enum default %q must name a value", value)
+	}
+	return IdentOption(toEnumValueName(protoType, value)), nil
+}
+
+// bitSize returns the size of a numeric scalar type.
+func bitSize(protoType string) int {
+	if strings.HasSuffix(protoType, "32") || protoType == "float" {
+		return 32
+	}
+	return 64
+}
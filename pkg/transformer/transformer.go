@@ -3,7 +3,11 @@ package transformer
 
 import (
 	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
 	"regexp"
+	"slices"
 	"strings"
 	"unicode"
 
@@ -14,87 +18,174 @@ import (
 // Proto represents a complete .proto file.
 type Proto struct {
 	Syntax   string
+	Edition  string // Set instead of Syntax for editions, e.g. "2023"
 	Package  string
-	Options  map[string]string
+	Options  map[string]OptionValue
 	Imports  []string
 	Enums    []ProtoEnum
 	Messages []ProtoMessage
 	Services []ProtoService
+	// Doc is the package documentation, rendered as a detached comment
+	// before the syntax statement.
+	Doc []string
+	// Diagnostics reports adjustments made while transforming. They are
+	// not rendered into the .proto file.
+	Diagnostics []Diagnostic
+}
+
+// Severity classifies a diagnostic.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "info"
+}
+
+// Diagnostic describes a change go2proto made to keep the output valid.
+type Diagnostic struct {
+	Severity Severity
+	Element  string // e.g. "enum Status"
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Element, d.Message)
 }
 
 // ProtoMessage represents a protobuf message.
 type ProtoMessage struct {
-	Name     string
-	Fields   []ProtoField
-	Nested   []ProtoMessage
-	Enums    []ProtoEnum
-	Comments []string
+	Name       string
+	Fields     []ProtoField
+	Nested     []ProtoMessage
+	Enums      []ProtoEnum
+	Oneofs     []ProtoOneof
+	Options    []ProtoOption
+	Comments   []string
+	Deprecated bool
+	Pos        token.Position // Go declaration the message was generated from
+}
+
+// ProtoOneof represents a oneof group. Member fields reference it by name.
+type ProtoOneof struct {
+	Name string
 }
 
 // ProtoField represents a field in a message.
 type ProtoField struct {
-	Name     string
-	Type     string
-	Number   int
-	Repeated bool
-	Optional bool
-	MapKey   string
-	MapValue string
-	Comments []string
+	Name       string
+	Type       string
+	Number     int
+	Repeated   bool
+	Optional   bool
+	MapKey     string
+	MapValue   string
+	Oneof      string
+	Required   bool         // proto2 only
+	Default    *OptionValue // proto2 and editions only
+	Options    []ProtoOption
+	Comments   []string
+	Deprecated bool
 }
 
 // ProtoEnum represents an enum type.
 type ProtoEnum struct {
-	Name     string
-	Values   []ProtoEnumValue
-	Comments []string
+	Name       string
+	Values     []ProtoEnumValue
+	AllowAlias bool
+	Options    []ProtoOption
+	Comments   []string
+	Deprecated bool
+	Pos        token.Position
 }
 
 // ProtoEnumValue represents an enum value.
 type ProtoEnumValue struct {
-	Name     string
-	Number   int
-	Comments []string
+	Name       string
+	Number     int
+	Options    []ProtoOption
+	Comments   []string
+	Deprecated bool
 }
 
 // ProtoService represents a gRPC service.
 type ProtoService struct {
-	Name     string
-	Methods  []ProtoRPC
-	Comments []string
+	Name    string
+	Methods []ProtoRPC
+	// ErrorDetails lists the messages the service returns in
+	// google.rpc.Status details.
+	ErrorDetails []string
+	Options      []ProtoOption
+	Comments     []string
+	Deprecated   bool
+	Pos          token.Position
 }
 
 // ProtoRPC represents an RPC method.
 type ProtoRPC struct {
-	Name            string
-	InputType       string
-	OutputType      string
-	ClientStreaming bool
-	ServerStreaming bool
-	Comments        []string
+	Name             string
+	InputType        string
+	OutputType       string
+	ClientStreaming  bool
+	ServerStreaming  bool
+	HTTP             *HTTPRule
+	IdempotencyLevel string // IDEMPOTENCY_UNKNOWN, NO_SIDE_EFFECTS or IDEMPOTENT
+	Options          []ProtoOption
+	Comments         []string
+	Deprecated       bool
+}
+
+// ProtoOption is an option set on a message, field, enum, enum value,
+// service or RPC. Custom extension names are written in parentheses, e.g.
+// "(acme.sensitive)".
+type ProtoOption struct {
+	Name  string
+	Value OptionValue
+}
+
+// HTTPRule is a google.api.http mapping of an RPC onto an HTTP endpoint.
+type HTTPRule struct {
+	Method       string // get, put, post, delete or patch
+	Path         string
+	Body         string
+	ResponseBody string
 }
 
 // ProtoMonoid allows composing Proto structures.
 var ProtoMonoid = ct.Monoid[Proto]{
 	Empty: func() Proto {
-		return Proto{Syntax: "proto3", Options: make(map[string]string)}
+		return Proto{Options: make(map[string]OptionValue)}
 	},
 	Append: func(a, b Proto) Proto {
-		opts := make(map[string]string)
+		opts := make(map[string]OptionValue)
 		for k, v := range a.Options {
 			opts[k] = v
 		}
 		for k, v := range b.Options {
 			opts[k] = v
 		}
+		doc := a.Doc
+		if len(doc) == 0 {
+			doc = b.Doc
+		}
 		return Proto{
 			Syntax:   ct.Coalesce(a.Syntax, b.Syntax),
+			Edition:  ct.Coalesce(a.Edition, b.Edition),
 			Package:  ct.Coalesce(a.Package, b.Package),
 			Options:  opts,
 			Imports:  ct.Unique(append(a.Imports, b.Imports...)),
 			Enums:    append(a.Enums, b.Enums...),
 			Messages: append(a.Messages, b.Messages...),
 			Services: append(a.Services, b.Services...),
+			Doc:      doc,
+
+			Diagnostics: append(a.Diagnostics, b.Diagnostics...),
 		}
 	},
 }
@@ -125,61 +216,233 @@ type TransformOptions struct {
 	TypeMappings   map[string]TypeMapping
 	IncludePrivate bool
 	ServiceSuffix  string
+	// RenameZeroEnumValue renames an existing zero enum constant to
+	// <ENUM>_UNSPECIFIED, following the proto3 style guide.
+	RenameZeroEnumValue bool
+	// DropEnumAliases keeps only the first constant for each enum number
+	// instead of emitting option allow_alias.
+	DropEnumAliases bool
+	// FlagStyle selects how bit-flag constants are documented.
+	FlagStyle FlagStyle
+	// WrapRPCMessages gives every RPC its own <Method>Request and
+	// <Method>Response message, even when a method takes or returns a
+	// single message type or nothing at all.
+	WrapRPCMessages bool
+	// IgnoredParamTypes lists parameter types left out of RPC requests,
+	// by import path and name (e.g. "net/http.Header"). Pointers to these
+	// types are ignored too.
+	IgnoredParamTypes []string
+	// SkipFuncOptions leaves variadic function-typed parameters, such as
+	// functional options, out of RPC requests.
+	SkipFuncOptions bool
+	// ManagedOptions derives java_package, csharp_namespace,
+	// objc_class_prefix, php_namespace and ruby_package from the proto
+	// package.
+	ManagedOptions bool
+	// FileOptions sets file options, overriding go_package and any
+	// managed option of the same name.
+	FileOptions map[string]OptionValue
+	// ErrorsAsStatus maps error-typed struct fields to google.rpc.Status
+	// instead of string.
+	ErrorsAsStatus bool
+	// MessageNaming resolves clashes between synthesized request/response
+	// messages and other declarations.
+	MessageNaming MessageNaming
+	// Ordering selects the order of enums, messages and services.
+	Ordering Ordering
+	// Syntax selects proto2, proto3 or editions output, and Edition the
+	// edition written for SyntaxEditions.
+	Syntax  Syntax
+	Edition string
+	// MergeImportCycles merges Go files whose declarations reference each
+	// other in a cycle into one .proto file under LayoutFile.
+	MergeImportCycles bool
 }
 
+// MessageNaming selects how a synthesized message name that clashes with
+// an existing declaration is made unique.
+type MessageNaming string
+
+const (
+	// MessageNamingService prefixes the service name, without
+	// ServiceSuffix, e.g. UserGetRequest.
+	MessageNamingService MessageNaming = "service"
+	// MessageNamingSuffix appends a number, e.g. GetRequest2.
+	MessageNamingSuffix MessageNaming = "suffix"
+)
+
+// FlagStyle selects how the named constants of a bit-flag type are
+// documented. Fields of a flag type are always emitted as integers.
+type FlagStyle string
+
+const (
+	// FlagStyleEnum emits a companion enum listing each flag.
+	FlagStyleEnum FlagStyle = "enum"
+	// FlagStyleComment lists the flags in the comments of each field.
+	FlagStyleComment FlagStyle = "comment"
+)
+
 // DefaultOptions returns sensible defaults.
 func DefaultOptions() TransformOptions {
-	return TransformOptions{TypeMappings: defaultTypeMappings, ServiceSuffix: "Service"}
+	return TransformOptions{
+		TypeMappings: defaultTypeMappings, ServiceSuffix: "Service",
+		FlagStyle: FlagStyleEnum, MessageNaming: MessageNamingService, Ordering: OrderingSource,
+		Syntax: SyntaxProto3, Edition: DefaultEdition,
+		IgnoredParamTypes: DefaultIgnoredParamTypes(), SkipFuncOptions: true,
+	}
+}
+
+// DefaultIgnoredParamTypes returns the parameter types that never become
+// request fields by default.
+func DefaultIgnoredParamTypes() []string {
+	return []string{"context.Context", "google.golang.org/grpc.CallOption"}
 }
 
 // Transformer converts Go packages to Proto definitions.
 type Transformer struct {
 	opts       TransformOptions
 	knownTypes map[string]bool
+	flags      map[string]flagSet // bit-flag types of the current package
+	symbols    map[string]bool    // declared names in the output file
+}
+
+// flagSet describes a bit-flag type whose constants are combined with |.
+type flagSet struct {
+	proto  string // integer type used for fields
+	values []ProtoEnumValue
+	diags  []Diagnostic // constants left out of values
 }
 
 // NewTransformer creates a new transformer.
 func NewTransformer(opts TransformOptions) *Transformer {
+	if opts.ErrorsAsStatus {
+		mappings := make(map[string]TypeMapping, len(opts.TypeMappings)+1)
+		for k, v := range opts.TypeMappings {
+			mappings[k] = v
+		}
+		mappings["error"] = TypeMapping{Proto: "google.rpc.Status", Import: "google/rpc/status.proto"}
+		opts.TypeMappings = mappings
+	}
 	return &Transformer{opts: opts, knownTypes: make(map[string]bool)}
 }
 
 // Transform converts Go packages to a Proto definition.
 func (t *Transformer) Transform(pkgs []parser.GoPackage) Proto {
-	return ct.FoldMap(pkgs, ProtoMonoid, t.transformPackage)
+	t.symbols = make(map[string]bool)
+	for _, pkg := range pkgs {
+		t.declareSymbols(pkg)
+	}
+	return orderDeclarations(ct.FoldMap(pkgs, ProtoMonoid, t.transformPackage), t.opts.Ordering)
 }
 
-func (t *Transformer) transformPackage(pkg parser.GoPackage) Proto {
-	protoPackage := t.opts.PackageName
-	if protoPackage == "" {
-		protoPackage = toProtoPackage(pkg.Path)
+// declareSymbols records the names a package contributes to the output,
+// so synthesized messages can avoid them.
+func (t *Transformer) declareSymbols(pkg parser.GoPackage) {
+	for _, s := range pkg.Structs {
+		t.symbols[s.Name] = true
+	}
+	for _, alias := range pkg.Aliases {
+		t.symbols[alias.Name] = true
+	}
+	for _, i := range pkg.Interfaces {
+		t.symbols[i.Name] = true
+	}
+}
+
+// uniqueMessageName reserves a name for a synthesized message, renaming it
+// according to MessageNaming if it is already taken. It reports whether
+// the name was changed.
+func (t *Transformer) uniqueMessageName(serviceName, name string) (string, bool) {
+	if !t.symbols[name] {
+		t.symbols[name] = true
+		return name, false
+	}
+	base := name
+	if t.opts.MessageNaming != MessageNamingSuffix {
+		prefix := strings.TrimSuffix(serviceName, t.opts.ServiceSuffix)
+		if prefix == "" {
+			prefix = serviceName
+		}
+		base = prefix + name
+	}
+	unique := base
+	for n := 2; t.symbols[unique]; n++ {
+		unique = fmt.Sprintf("%s%d", base, n)
 	}
+	t.symbols[unique] = true
+	return unique, true
+}
+
+// protoPackage returns the proto package of the file generated for pkg.
+func (t *Transformer) protoPackage(pkg parser.GoPackage) string {
+	return ct.Coalesce(t.opts.PackageName, toProtoPackage(pkg.Path))
+}
+
+func (t *Transformer) transformPackage(pkg parser.GoPackage) Proto {
+	result := t.transformDecls(pkg, pkg)
+	result.Diagnostics = append(result.Diagnostics, checkServices(result, result)...)
+	return result
+}
+
+// transformDecls converts decls, a subset of the declarations of pkg.
+// Enum and flag types are looked up in all of pkg.
+func (t *Transformer) transformDecls(pkg, decls parser.GoPackage) Proto {
+	protoPackage := t.protoPackage(pkg)
 	goPackage := t.opts.GoPackage
 	if goPackage == "" {
 		goPackage = pkg.Path
 	}
 
+	t.flags = t.buildFlagLookup(pkg)
 	enumLookup := t.buildEnumLookup(pkg)
 
+	options := map[string]OptionValue{"go_package": StringOption(goPackage)}
+	if t.opts.Syntax == SyntaxEditions {
+		// Match proto3: scalars without a pointer have no presence.
+		options[fieldPresence] = IdentOption("IMPLICIT")
+	}
+	if t.opts.ManagedOptions {
+		for k, v := range managedOptions(protoPackage) {
+			options[k] = v
+		}
+	}
+	for k, v := range t.opts.FileOptions {
+		options[k] = v
+	}
 	base := Proto{
-		Syntax:  "proto3",
+		Syntax:  string(t.opts.Syntax),
 		Package: protoPackage,
-		Options: map[string]string{"go_package": goPackage},
+		Options: options,
+		Doc:     filterNonTagComments(decls.Doc),
+	}
+	if t.opts.Syntax == SyntaxEditions {
+		base.Syntax, base.Edition = "", ct.Coalesce(t.opts.Edition, DefaultEdition)
 	}
 
-	enums := t.transformEnums(pkg, enumLookup)
-	messages := ct.FoldMap(pkg.Structs, ProtoMonoid, func(s parser.GoStruct) Proto {
+	enums := ct.Concat(ProtoMonoid, []Proto{t.transformEnums(decls, enumLookup), t.transformFlags(decls)})
+	messages := ct.FoldMap(decls.Structs, ProtoMonoid, func(s parser.GoStruct) Proto {
 		return t.transformStruct(s, enumLookup)
 	})
-	services := ct.FoldMap(pkg.Interfaces, ProtoMonoid, func(i parser.GoInterface) Proto {
-		return t.transformInterface(i)
+	services := ct.FoldMap(decls.Interfaces, ProtoMonoid, func(i parser.GoInterface) Proto {
+		return t.transformInterface(i, enumLookup)
 	})
 
 	return ct.Concat(ProtoMonoid, []Proto{base, enums, messages, services})
 }
 
+// checkServices checks the services of p against the messages of pkg,
+// the whole package p is part of.
+func checkServices(p, pkg Proto) []Diagnostic {
+	scope := Proto{Messages: pkg.Messages, Services: p.Services}
+	return append(checkHTTPRules(scope), checkErrorDetails(scope)...)
+}
+
 func (t *Transformer) buildEnumLookup(pkg parser.GoPackage) map[string]bool {
 	lookup := make(map[string]bool)
 	for _, cg := range pkg.Consts {
+		if _, isFlags := t.flags[cg.TypeName]; isFlags {
+			continue
+		}
 		if len(cg.Values) >= 2 {
 			lookup[cg.TypeName] = true
 		}
@@ -192,21 +455,253 @@ func (t *Transformer) buildEnumLookup(pkg parser.GoPackage) map[string]bool {
 	return lookup
 }
 
+// buildFlagLookup finds bit-flag types: those marked +go2proto:flags and
+// those whose constants are three or more distinct powers of two, as
+// produced by 1 << iota.
+func (t *Transformer) buildFlagLookup(pkg parser.GoPackage) map[string]flagSet {
+	underlying := make(map[string]parser.GoType)
+	tags := make(map[string]string)
+	for _, alias := range pkg.Aliases {
+		underlying[alias.Name] = alias.Underlying
+		tags[alias.Name] = alias.Tags["go2proto:flags"]
+	}
+
+	lookup := make(map[string]flagSet)
+	for _, cg := range pkg.Consts {
+		if tags[cg.TypeName] == "false" {
+			continue
+		}
+		if tags[cg.TypeName] != "true" && !isPowerOfTwoSet(cg.Values) {
+			continue
+		}
+		flags := flagSet{proto: "uint64"}
+		if basic, ok := underlying[cg.TypeName].(parser.BasicType); ok {
+			if mapping, ok := t.opts.TypeMappings[basic.Name]; ok && isBasicProtoType(mapping.Proto) {
+				flags.proto = mapping.Proto
+			}
+		}
+		flags.values, flags.diags = enumValues(cg)
+		lookup[cg.TypeName] = flags
+	}
+	return lookup
+}
+
+// transformFlags emits a companion enum documenting each bit-flag type
+// when FlagStyle is FlagStyleEnum.
+func (t *Transformer) transformFlags(pkg parser.GoPackage) Proto {
+	aliases := aliasLookup(pkg)
+	var enums []ProtoEnum
+	var diags []Diagnostic
+	for _, cg := range pkg.Consts {
+		flags, ok := t.flags[cg.TypeName]
+		if !ok {
+			continue
+		}
+		diags = append(diags, flags.diags...)
+		if t.opts.FlagStyle == FlagStyleComment {
+			continue
+		}
+		alias := aliases[cg.TypeName]
+		enum := ProtoEnum{
+			Name:   cg.TypeName,
+			Values: append([]ProtoEnumValue(nil), flags.values...),
+			Comments: append(filterNonTagComments(alias.Comments),
+				fmt.Sprintf("%s values are bit flags; fields of this type hold a bitwise OR of them.", cg.TypeName)),
+			Options:    optionsFromTags(alias.Tags),
+			Deprecated: alias.Deprecated != "",
+			Pos:        enumPos(cg, alias),
+		}
+		if t.opts.Syntax != SyntaxProto2 && !hasEnumNumber(enum.Values, 0) {
+			enum.Values = append([]ProtoEnumValue{{Name: toEnumValueName(cg.TypeName, "Unspecified"), Number: 0}}, enum.Values...)
+		}
+		enum, rangeDiags := checkEnumNumbers(enum)
+		enum, aliasDiags := t.resolveAliases(enum)
+		enum, zeroDiags := t.ensureZeroValue(enum)
+		enums = append(enums, enum)
+		diags = append(append(append(diags, rangeDiags...), aliasDiags...), zeroDiags...)
+	}
+	return Proto{Enums: enums, Diagnostics: diags}
+}
+
+// flagComments documents a field that holds a bit-flag type.
+func (t *Transformer) flagComments(goType parser.GoType) []string {
+	name := localTypeName(goType)
+	flags, ok := t.flags[name]
+	if !ok {
+		return nil
+	}
+	if t.opts.FlagStyle != FlagStyleComment {
+		return []string{fmt.Sprintf("Bitwise OR of %s values.", name)}
+	}
+	comments := []string{fmt.Sprintf("Bitwise OR of %s flags:", name)}
+	for _, v := range flags.values {
+		comments = append(comments, fmt.Sprintf("  %s = %d", v.Name, v.Number))
+	}
+	return comments
+}
+
 func (t *Transformer) transformEnums(pkg parser.GoPackage, enumLookup map[string]bool) Proto {
+	aliases := aliasLookup(pkg)
 	var enums []ProtoEnum
+	var imports []string
+	var diags []Diagnostic
 	for _, cg := range pkg.Consts {
 		if !enumLookup[cg.TypeName] {
 			continue
 		}
-		enum := ProtoEnum{Name: cg.TypeName}
+		alias := aliases[cg.TypeName]
+		enum := ProtoEnum{
+			Name: cg.TypeName, Comments: filterNonTagComments(alias.Comments), Deprecated: alias.Deprecated != "",
+			Options: optionsFromTags(alias.Tags), Pos: enumPos(cg, alias),
+		}
+		values, valueDiags := enumValues(cg)
+		enum.Values = values
 		for _, cv := range cg.Values {
-			enum.Values = append(enum.Values, ProtoEnumValue{
-				Name: toEnumValueName(cg.TypeName, cv.Name), Number: int(cv.Value), Comments: cv.Comments,
-			})
+			imports = append(imports, importsFromTags(cv.Tags)...)
 		}
+		enum, rangeDiags := checkEnumNumbers(enum)
+		enum, aliasDiags := t.resolveAliases(enum)
+		enum, zeroDiags := t.ensureZeroValue(enum)
 		enums = append(enums, enum)
+		imports = append(imports, importsFromTags(alias.Tags)...)
+		diags = append(append(append(append(diags, valueDiags...), rangeDiags...), aliasDiags...), zeroDiags...)
+	}
+	return Proto{Enums: enums, Imports: ct.Unique(imports), Diagnostics: diags}
+}
+
+// enumValues converts the constants of cg to enum values. Constants too
+// large for an int64 have no usable number and are left out with a
+// warning.
+func enumValues(cg parser.GoConstGroup) ([]ProtoEnumValue, []Diagnostic) {
+	var values []ProtoEnumValue
+	var diags []Diagnostic
+	for _, cv := range cg.Values {
+		name := toEnumValueName(cg.TypeName, cv.Name)
+		if cv.Overflow {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning, Element: "enum " + cg.TypeName,
+				Message: fmt.Sprintf("dropped %s because its value overflows an int64", name),
+			})
+			continue
+		}
+		values = append(values, ProtoEnumValue{
+			Name: name, Number: int(cv.Value), Comments: filterNonTagComments(cv.Comments),
+			Options: optionsFromTags(cv.Tags), Deprecated: cv.Deprecated != "",
+		})
 	}
-	return Proto{Enums: enums}
+	return values, diags
+}
+
+// checkEnumNumbers drops values outside the int32 range of enum numbers,
+// which protoc rejects.
+func checkEnumNumbers(enum ProtoEnum) (ProtoEnum, []Diagnostic) {
+	var values []ProtoEnumValue
+	var diags []Diagnostic
+	for _, v := range enum.Values {
+		if v.Number >= math.MinInt32 && v.Number <= math.MaxInt32 {
+			values = append(values, v)
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Severity: SeverityWarning, Element: "enum " + enum.Name,
+			Message: fmt.Sprintf("dropped %s = %d because enum numbers must fit in an int32", v.Name, v.Number),
+		})
+	}
+	enum.Values = values
+	return enum, diags
+}
+
+// resolveAliases handles constants that share a number. By default the
+// enum is marked allow_alias; with DropEnumAliases only the first
+// constant for each number is kept.
+func (t *Transformer) resolveAliases(enum ProtoEnum) (ProtoEnum, []Diagnostic) {
+	canonical := make(map[int]string)
+	var values []ProtoEnumValue
+	var diags []Diagnostic
+	for _, v := range enum.Values {
+		first, seen := canonical[v.Number]
+		if !seen {
+			canonical[v.Number] = v.Name
+			values = append(values, v)
+			continue
+		}
+		if !t.opts.DropEnumAliases {
+			enum.AllowAlias = true
+			values = append(values, v)
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Severity: SeverityWarning, Element: "enum " + enum.Name,
+			Message: fmt.Sprintf("dropped alias %s = %d in favor of %s", v.Name, v.Number, first),
+		})
+	}
+	enum.Values = values
+	return enum, diags
+}
+
+// ensureZeroValue makes the first value of an enum zero, as proto3 and
+// open editions enums require. A missing zero value is inserted as
+// <ENUM>_UNSPECIFIED; an existing one is moved to the front and
+// optionally renamed. proto2 enums are left alone.
+func (t *Transformer) ensureZeroValue(enum ProtoEnum) (ProtoEnum, []Diagnostic) {
+	if t.opts.Syntax == SyntaxProto2 {
+		return enum, nil
+	}
+	element := "enum " + enum.Name
+	unspecified := toEnumValueName(enum.Name, "Unspecified")
+	requires := "proto3 requires"
+	if t.opts.Syntax == SyntaxEditions {
+		requires = "open enums require"
+	}
+	zero := -1
+	for i, v := range enum.Values {
+		if v.Number == 0 {
+			zero = i
+			break
+		}
+	}
+
+	taken := make(map[string]bool, len(enum.Values))
+	for _, v := range enum.Values {
+		taken[v.Name] = true
+	}
+
+	if zero < 0 {
+		// A constant named Unspecified with a non-zero value keeps its name.
+		base := unspecified
+		for n := 2; taken[unspecified]; n++ {
+			unspecified = fmt.Sprintf("%s_%d", base, n)
+		}
+		enum.Values = append([]ProtoEnumValue{{Name: unspecified, Number: 0}}, enum.Values...)
+		return enum, []Diagnostic{{
+			Severity: SeverityWarning, Element: element,
+			Message: fmt.Sprintf("no zero constant; inserted %s = 0 because %s the first value to be 0", unspecified, requires),
+		}}
+	}
+
+	var diags []Diagnostic
+	if zero > 0 {
+		values := append([]ProtoEnumValue{enum.Values[zero]}, enum.Values[:zero]...)
+		enum.Values = append(values, enum.Values[zero+1:]...)
+		diags = append(diags, Diagnostic{
+			Severity: SeverityWarning, Element: element,
+			Message: fmt.Sprintf("moved %s to the front because %s the first value to be 0", enum.Values[0].Name, requires),
+		})
+	}
+	if t.opts.RenameZeroEnumValue && enum.Values[0].Name != unspecified {
+		if taken[unspecified] {
+			return enum, append(diags, Diagnostic{
+				Severity: SeverityWarning, Element: element,
+				Message: fmt.Sprintf("kept zero value %s because %s is taken by another value", enum.Values[0].Name, unspecified),
+			})
+		}
+		diags = append(diags, Diagnostic{
+			Severity: SeverityInfo, Element: element,
+			Message: fmt.Sprintf("renamed zero value %s to %s", enum.Values[0].Name, unspecified),
+		})
+		enum.Values[0].Name = unspecified
+	}
+	return enum, diags
 }
 
 func (t *Transformer) transformStruct(s parser.GoStruct, enumLookup map[string]bool) Proto {
@@ -223,11 +718,24 @@ func (t *Transformer) transformStruct(s parser.GoStruct, enumLookup map[string]b
 		typeParamsLookup[tp] = true
 	}
 
-	msg := ProtoMessage{Name: s.Name, Comments: filterNonTagComments(s.Comments)}
+	msg := ProtoMessage{
+		Name: s.Name, Options: optionsFromTags(s.Tags),
+		Comments: filterNonTagComments(s.Comments), Deprecated: s.Deprecated != "",
+		Pos: s.Pos,
+	}
+	fieldImports, diags := t.transformFields(&msg, s.Fields, enumLookup, typeParamsLookup)
+	imports := append(importsFromTags(s.Tags), fieldImports...)
+	return Proto{Messages: []ProtoMessage{msg}, Imports: ct.Unique(imports), Diagnostics: diags}
+}
+
+// transformFields appends the fields of a struct, or of a synthesized RPC
+// message, to msg and returns the imports they need.
+func (t *Transformer) transformFields(msg *ProtoMessage, fields []parser.GoField, enumLookup map[string]bool, typeParamsLookup map[string]bool) ([]string, []Diagnostic) {
 	var imports []string
-	fieldNum := 1
+	var diags []Diagnostic
+	fieldNum := len(msg.Fields) + 1
 
-	for _, f := range s.Fields {
+	for _, f := range fields {
 		if !f.Exported && !t.opts.IncludePrivate {
 			continue
 		}
@@ -235,18 +743,36 @@ func (t *Transformer) transformStruct(s parser.GoStruct, enumLookup map[string]b
 			continue
 		}
 		protoField, fieldImports := t.transformField(f, fieldNum, enumLookup, typeParamsLookup)
+		protoField.Options = optionsFromTags(f.Tags)
+		fieldImports = append(fieldImports, importsFromTags(f.Tags)...)
 		if protoField.Name != "" {
+			element := fmt.Sprintf("field %s.%s", msg.Name, protoField.Name)
+			switch group := toSnakeCase(f.Tags["go2proto:oneof"]); {
+			case group == "":
+			case !canBeOneofMember(protoField):
+				diags = append(diags, Diagnostic{
+					Severity: SeverityWarning, Element: element,
+					Message: fmt.Sprintf("left out of oneof %s because repeated and map fields cannot be oneof members", group),
+				})
+			default:
+				protoField.Oneof = group
+				protoField.Optional = false
+				if !hasOneof(msg.Oneofs, group) {
+					msg.Oneofs = append(msg.Oneofs, ProtoOneof{Name: group})
+				}
+			}
+			diags = append(diags, t.applyPresence(&protoField, f, enumLookup, element)...)
 			msg.Fields = append(msg.Fields, protoField)
 			imports = append(imports, fieldImports...)
 			fieldNum++
 		}
 	}
-
-	return Proto{Messages: []ProtoMessage{msg}, Imports: ct.Unique(imports)}
+	return imports, diags
 }
 
 func (t *Transformer) transformField(f parser.GoField, num int, enumLookup map[string]bool, typeParamsLookup map[string]bool) (ProtoField, []string) {
 	if tag := parseProtobufTag(f.Tag); tag != nil {
+		tag.Deprecated = f.Deprecated != ""
 		return *tag, nil
 	}
 
@@ -262,7 +788,9 @@ func (t *Transformer) transformField(f parser.GoField, num int, enumLookup map[s
 	return ProtoField{
 		Name: toSnakeCase(f.Name), Type: protoType, Number: num,
 		Repeated: repeated, Optional: optional,
-		MapKey: mapKey, MapValue: mapValue, Comments: filterNonTagComments(f.Comments),
+		MapKey: mapKey, MapValue: mapValue,
+		Comments:   append(filterNonTagComments(f.Comments), t.flagComments(f.Type)...),
+		Deprecated: f.Deprecated != "",
 	}, imports
 }
 
@@ -307,6 +835,10 @@ func (t *Transformer) transformType(goType parser.GoType, enumLookup map[string]
 		return
 	case parser.NamedType:
 		fullName := v.String()
+		if flags, ok := t.flags[v.Name]; ok && v.Package == "" {
+			protoType = flags.proto
+			return
+		}
 		if enumLookup[v.Name] {
 			protoType = v.Name
 			return
@@ -355,7 +887,7 @@ func (t *Transformer) transformType(goType parser.GoType, enumLookup map[string]
 	}
 }
 
-func (t *Transformer) transformInterface(i parser.GoInterface) Proto {
+func (t *Transformer) transformInterface(i parser.GoInterface, enumLookup map[string]bool) Proto {
 	if i.Tags["go2proto:service"] != "true" && i.Tags["go2proto"] != "service" {
 		return ProtoMonoid.Empty()
 	}
@@ -363,115 +895,550 @@ func (t *Transformer) transformInterface(i parser.GoInterface) Proto {
 	serviceName := i.Name
 	// Keep the full interface name to avoid collision with message types
 
-	service := ProtoService{Name: serviceName, Comments: filterNonTagComments(i.Comments)}
-	var messages []ProtoMessage
-	var imports []string
-
-	for _, m := range i.Methods {
-		rpc, reqMsg, respMsg, methodImports := t.transformMethod(m, serviceName)
+	service := ProtoService{
+		Name: serviceName, Comments: filterNonTagComments(i.Comments), Deprecated: i.Deprecated != "",
+		ErrorDetails: parseErrorTypes(i.Tags["go2proto:errors"]),
+		Options:      optionsFromTags(i.Tags),
+		Pos:          i.Pos,
+	}
+	included := ct.Filter(i.Methods, func(m parser.GoMethod) bool {
+		return m.Tags["go2proto"] != "false"
+	})
+	methods := ct.FoldMap(included, ProtoMonoid, func(m parser.GoMethod) Proto {
+		rpc, methodProto := t.transformMethod(m, serviceName, enumLookup)
+		for i := range methodProto.Messages {
+			methodProto.Messages[i].Pos = m.Pos
+		}
 		service.Methods = append(service.Methods, rpc)
-		if reqMsg != nil {
-			messages = append(messages, *reqMsg)
+		return methodProto
+	})
+	methods.Services = []ProtoService{service}
+	methods.Imports = ct.Unique(append(importsFromTags(i.Tags), methods.Imports...))
+	return methods
+}
+
+// transformMethod converts an interface method to an RPC. The returned
+// Proto carries the synthesized request and response messages together
+// with their imports and diagnostics.
+func (t *Transformer) transformMethod(m parser.GoMethod, serviceName string, enumLookup map[string]bool) (ProtoRPC, Proto) {
+	rpc := ProtoRPC{
+		Name:     ct.Coalesce(m.Tags["go2proto:name"], m.Name),
+		Options:  optionsFromTags(m.Tags),
+		Comments: filterNonTagComments(m.Comments), Deprecated: m.Deprecated != "",
+	}
+	element := fmt.Sprintf("rpc %s.%s", serviceName, rpc.Name)
+	imports := importsFromTags(m.Tags)
+	var diags []Diagnostic
+	if level, ok := m.Tags["go2proto:idempotency_level"]; ok {
+		switch level {
+		case "IDEMPOTENCY_UNKNOWN", "NO_SIDE_EFFECTS", "IDEMPOTENT":
+			rpc.IdempotencyLevel = level
+		default:
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning, Element: element,
+				Message: fmt.Sprintf("unknown idempotency_level %s; expected IDEMPOTENCY_UNKNOWN, NO_SIDE_EFFECTS or IDEMPOTENT", level),
+			})
 		}
-		if respMsg != nil {
-			messages = append(messages, *respMsg)
+	}
+	if directive, ok := m.Tags["go2proto:http"]; ok {
+		rule, err := parseHTTPRule(directive)
+		if err != nil {
+			diags = append(diags, Diagnostic{Severity: SeverityWarning, Element: element, Message: err.Error()})
+		} else {
+			rpc.HTTP = rule
+			imports = append(imports, "google/api/annotations.proto")
 		}
-		imports = append(imports, methodImports...)
+	}
+	var messages []ProtoMessage
+	messageName := func(name string) string {
+		unique, renamed := t.uniqueMessageName(serviceName, name)
+		if renamed {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning, Element: element,
+				Message: fmt.Sprintf("%s is already declared; named the message %s", name, unique),
+			})
+		}
+		return unique
 	}
 
-	return Proto{Services: []ProtoService{service}, Messages: messages, Imports: ct.Unique(imports)}
-}
-
-func (t *Transformer) transformMethod(m parser.GoMethod, serviceName string) (ProtoRPC, *ProtoMessage, *ProtoMessage, []string) {
-	rpc := ProtoRPC{Name: m.Name}
-	var imports []string
-	var reqMsg, respMsg *ProtoMessage
-
+	metadataNames := splitList(m.Tags["go2proto:metadata"])
+	var metadata []parser.GoParam
 	params := ct.Filter(m.Params, func(p parser.GoParam) bool {
-		if named, ok := p.Type.(parser.NamedType); ok {
-			return !(named.Package == "context" && named.Name == "Context")
+		if t.isIgnoredParam(p) {
+			return false
+		}
+		if slices.Contains(metadataNames, p.Name) {
+			metadata = append(metadata, p)
+			return false
+		}
+		return true
+	})
+	rpc.Comments = append(rpc.Comments, metadataComments(metadata)...)
+	results := ct.Filter(m.Results, func(p parser.GoParam) bool {
+		if basic, ok := p.Type.(parser.BasicType); ok {
+			return basic.Name != "error"
 		}
 		return true
 	})
 
-	if len(params) == 1 {
-		if named, ok := params[0].Type.(parser.NamedType); ok {
-			rpc.InputType = named.Name
-		} else {
-			reqMsg = t.generateRequestMessage(m.Name, params)
-			rpc.InputType = reqMsg.Name
+	// io.Reader and io.Writer transfer bytes as a stream of chunk messages.
+	var clientBytes, serverBytes bool
+	params = ct.Filter(params, func(p parser.GoParam) bool {
+		switch {
+		case isIOType(p.Type, "Reader", "ReadCloser"):
+			clientBytes = true
+		case isIOType(p.Type, "Writer", "WriteCloser"):
+			serverBytes = true
+		default:
+			return true
+		}
+		return false
+	})
+	results = ct.Filter(results, func(r parser.GoParam) bool {
+		switch {
+		case isIOType(r.Type, "Reader", "ReadCloser"):
+			serverBytes = true
+		case isIOType(r.Type, "Writer", "WriteCloser"):
+			clientBytes = true
+		default:
+			return true
+		}
+		return false
+	})
+	inputType := m.Tags["go2proto:request"]
+	outputType := m.Tags["go2proto:response"]
+	if clientBytes {
+		chunks, chunkImports, chunkDiags := t.generateChunkMessages(rpc.Name+"Request", params, false, messageName, enumLookup)
+		rpc.ClientStreaming = true
+		inputType = ct.Coalesce(inputType, chunks[len(chunks)-1].Name)
+		messages = append(messages, chunks...)
+		imports = append(imports, chunkImports...)
+		diags = append(diags, chunkDiags...)
+		params = nil
+	}
+	if serverBytes {
+		chunks, chunkImports, chunkDiags := t.generateChunkMessages(rpc.Name+"Response", results, true, messageName, enumLookup)
+		rpc.ServerStreaming = true
+		outputType = ct.Coalesce(outputType, chunks[len(chunks)-1].Name)
+		messages = append(messages, chunks...)
+		imports = append(imports, chunkImports...)
+		diags = append(diags, chunkDiags...)
+		results = nil
+	}
+
+	// Channels, iterators and callback sinks stream their element type.
+	var clientStream, serverStream []parser.GoParam
+	params = ct.Filter(params, func(p parser.GoParam) bool {
+		if elem, ok := clientStreamElem(p.Type); ok {
+			clientStream = append(clientStream, parser.GoParam{Name: p.Name, Type: elem})
+			return false
+		}
+		if elem, ok := sinkElem(p.Type); ok {
+			serverStream = append(serverStream, parser.GoParam{Name: p.Name, Type: elem})
+			return false
+		}
+		return true
+	})
+	results = ct.Filter(results, func(r parser.GoParam) bool {
+		elems, ok := serverStreamElems(r.Type)
+		if ok && len(elems) == 1 && r.Name != "" {
+			elems[0].Name = r.Name
+		}
+		if ok {
+			serverStream = append(serverStream, elems...)
+		}
+		return !ok
+	})
+	if len(clientStream) > 0 {
+		rpc.ClientStreaming = true
+		if len(params) > 0 {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning, Element: element,
+				Message: "dropped parameters alongside a client stream; only the stream element is sent",
+			})
+		}
+		params = clientStream
+	}
+	if len(serverStream) > 0 {
+		rpc.ServerStreaming = true
+		if len(results) > 0 {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityWarning, Element: element,
+				Message: "dropped results alongside a server stream; only the stream element is returned",
+			})
 		}
-	} else if len(params) > 1 {
-		reqMsg = t.generateRequestMessage(m.Name, params)
+		results = serverStream
+	}
+
+	if inputType == "" && len(params) == 1 && !params[0].Variadic && !t.opts.WrapRPCMessages {
+		inputType = t.messageType(params[0].Type, enumLookup)
+	}
+	switch {
+	case inputType != "":
+		rpc.InputType = inputType
+	case len(params) > 0 || t.opts.WrapRPCMessages:
+		reqMsg, reqImports, reqDiags := t.generateRequestMessage(messageName(rpc.Name+"Request"), params, enumLookup)
 		rpc.InputType = reqMsg.Name
-	} else {
+		messages = append(messages, *reqMsg)
+		imports = append(imports, reqImports...)
+		diags = append(diags, reqDiags...)
+	default:
 		rpc.InputType = "google.protobuf.Empty"
 		imports = append(imports, "google/protobuf/empty.proto")
 	}
 
-	results := ct.Filter(m.Results, func(p parser.GoParam) bool {
-		if basic, ok := p.Type.(parser.BasicType); ok {
-			return basic.Name != "error"
+	if outputType == "" && len(results) == 1 && !t.opts.WrapRPCMessages {
+		outputType = t.messageType(derefType(results[0].Type), enumLookup)
+	}
+	switch {
+	case outputType != "":
+		rpc.OutputType = outputType
+	case len(results) > 0 || t.opts.WrapRPCMessages:
+		respMsg, respImports, respDiags := t.generateResponseMessage(messageName(rpc.Name+"Response"), results, enumLookup)
+		rpc.OutputType = respMsg.Name
+		messages = append(messages, *respMsg)
+		imports = append(imports, respImports...)
+		diags = append(diags, respDiags...)
+	default:
+		rpc.OutputType = "google.protobuf.Empty"
+		imports = append(imports, "google/protobuf/empty.proto")
+	}
+
+	return rpc, Proto{Messages: messages, Imports: imports, Diagnostics: diags}
+}
+
+const optionTagPrefix = "go2proto:option:"
+
+// optionsFromTags collects +go2proto:option:<name>=<value> directives, such
+// as +go2proto:option:json_name=userId or +go2proto:option:(acme.pii)=true,
+// sorted by name.
+func optionsFromTags(tags map[string]string) []ProtoOption {
+	var options []ProtoOption
+	for key, value := range tags {
+		if name, ok := strings.CutPrefix(key, optionTagPrefix); ok && name != "" {
+			options = append(options, ProtoOption{Name: name, Value: ParseOptionValue(value)})
 		}
-		return true
+	}
+	slices.SortFunc(options, func(a, b ProtoOption) int { return strings.Compare(a.Name, b.Name) })
+	return options
+}
+
+// importsFromTags returns the files listed in a +go2proto:import directive,
+// typically those defining custom extension options.
+func importsFromTags(tags map[string]string) []string {
+	return splitList(tags["go2proto:import"])
+}
+
+// parseErrorTypes parses a +go2proto:errors directive listing the error
+// types of a service, e.g. "*NotFoundError, ValidationError".
+func parseErrorTypes(directive string) []string {
+	return ct.Map(splitList(directive), func(name string) string {
+		return strings.TrimPrefix(name, "*")
 	})
+}
+
+// isIgnoredParam reports whether a method parameter is transport plumbing
+// rather than request data.
+func (t *Transformer) isIgnoredParam(p parser.GoParam) bool {
+	if p.Variadic && p.IsFunc && t.opts.SkipFuncOptions {
+		return true
+	}
+	named, ok := derefType(p.Type).(parser.NamedType)
+	return ok && slices.Contains(t.opts.IgnoredParamTypes, named.String())
+}
+
+// metadataComments documents parameters marked +go2proto:metadata, which
+// travel as request headers instead of message fields.
+func metadataComments(params []parser.GoParam) []string {
+	if len(params) == 0 {
+		return nil
+	}
+	comments := []string{"Request metadata (sent as headers):"}
+	for _, p := range params {
+		header := strings.ReplaceAll(toSnakeCase(p.Name), "_", "-")
+		comments = append(comments, fmt.Sprintf("  %s: %s", header, p.Type))
+	}
+	return comments
+}
 
-	if len(results) == 1 {
-		resultType := results[0].Type
-		if ptr, ok := resultType.(parser.PointerType); ok {
-			resultType = ptr.Elem
+// splitList splits a comma-separated directive value.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-		if named, ok := resultType.(parser.NamedType); ok {
-			rpc.OutputType = named.Name
-		} else {
-			respMsg = t.generateResponseMessage(m.Name, results)
-			rpc.OutputType = respMsg.Name
+	}
+	return items
+}
+
+// checkErrorDetails verifies that each registered error type of a service
+// is generated as a message.
+func checkErrorDetails(p Proto) []Diagnostic {
+	messages := make(map[string]bool)
+	for _, m := range p.Messages {
+		messages[m.Name] = true
+	}
+	var diags []Diagnostic
+	for _, s := range p.Services {
+		for _, name := range s.ErrorDetails {
+			if !messages[name] {
+				diags = append(diags, Diagnostic{
+					Severity: SeverityWarning, Element: "service " + s.Name,
+					Message: fmt.Sprintf("error type %s is not an exported struct in this package", name),
+				})
+			}
 		}
-	} else if len(results) > 1 {
-		respMsg = t.generateResponseMessage(m.Name, results)
-		rpc.OutputType = respMsg.Name
+	}
+	return diags
+}
+
+// parseHTTPRule parses a +go2proto:http directive of the form
+// "GET /v1/users/{id} body=* response_body=user".
+func parseHTTPRule(directive string) (*HTTPRule, error) {
+	parts := strings.Fields(directive)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("http directive %q needs a method and a path", directive)
+	}
+	rule := &HTTPRule{Method: strings.ToLower(parts[0]), Path: parts[1]}
+	switch rule.Method {
+	case "get", "put", "post", "delete", "patch":
+	default:
+		return nil, fmt.Errorf("http directive %q has unsupported method %s", directive, parts[0])
+	}
+	if !strings.HasPrefix(rule.Path, "/") {
+		return nil, fmt.Errorf("http directive %q has a path not starting with /", directive)
+	}
+	for _, opt := range parts[2:] {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "body":
+			rule.Body = value
+		case "response_body":
+			rule.ResponseBody = value
+		default:
+			return nil, fmt.Errorf("http directive %q has unknown setting %s", directive, key)
+		}
+	}
+	return rule, nil
+}
+
+var httpPathVariable = regexp.MustCompile(`\{([^}=]+)(=[^}]*)?\}`)
+
+// checkHTTPRules verifies that the path variables and body of each HTTP
+// rule name fields of the RPC's request message.
+func checkHTTPRules(p Proto) []Diagnostic {
+	messages := make(map[string]ProtoMessage)
+	for _, m := range p.Messages {
+		messages[m.Name] = m
+	}
+	var diags []Diagnostic
+	for _, s := range p.Services {
+		for _, rpc := range s.Methods {
+			if rpc.HTTP == nil {
+				continue
+			}
+			element := fmt.Sprintf("rpc %s.%s", s.Name, rpc.Name)
+			warn := func(format string, args ...any) {
+				diags = append(diags, Diagnostic{Severity: SeverityWarning, Element: element, Message: fmt.Sprintf(format, args...)})
+			}
+			for _, match := range httpPathVariable.FindAllStringSubmatch(rpc.HTTP.Path, -1) {
+				if !hasFieldPath(messages, rpc.InputType, match[1]) {
+					warn("path variable {%s} is not a field of %s", match[1], rpc.InputType)
+				}
+			}
+			switch {
+			case rpc.HTTP.Body != "" && (rpc.HTTP.Method == "get" || rpc.HTTP.Method == "delete"):
+				warn("%s requests cannot have a body", strings.ToUpper(rpc.HTTP.Method))
+			case rpc.HTTP.Body != "" && rpc.HTTP.Body != "*" && !hasFieldPath(messages, rpc.InputType, rpc.HTTP.Body):
+				warn("body %s is not a field of %s", rpc.HTTP.Body, rpc.InputType)
+			}
+			if rpc.HTTP.ResponseBody != "" && !hasFieldPath(messages, rpc.OutputType, rpc.HTTP.ResponseBody) {
+				warn("response_body %s is not a field of %s", rpc.HTTP.ResponseBody, rpc.OutputType)
+			}
+		}
+	}
+	return diags
+}
+
+// hasFieldPath reports whether a dotted field path such as "user.id"
+// resolves through the named message and its message-typed fields.
+func hasFieldPath(messages map[string]ProtoMessage, messageName, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		msg, ok := messages[messageName]
+		if !ok {
+			return false
+		}
+		field, ok := findField(msg.Fields, name)
+		if !ok {
+			return false
+		}
+		messageName = field.Type
+	}
+	return true
+}
+
+func findField(fields []ProtoField, name string) (ProtoField, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return ProtoField{}, false
+}
+
+// clientStreamElem returns the element type of a parameter the caller
+// streams to the method: a receive-only channel.
+func clientStreamElem(goType parser.GoType) (parser.GoType, bool) {
+	if ch, ok := goType.(parser.ChanType); ok && ch.Dir == ast.RECV {
+		return derefType(ch.Elem), true
+	}
+	return nil, false
+}
+
+// sinkElem returns the element type of a parameter the method streams
+// back through: a send-only channel or a func(T) error callback.
+func sinkElem(goType parser.GoType) (parser.GoType, bool) {
+	switch v := goType.(type) {
+	case parser.ChanType:
+		if v.Dir == ast.SEND {
+			return derefType(v.Elem), true
+		}
+	case parser.FuncType:
+		if len(v.Params) != 1 {
+			return nil, false
+		}
+		if len(v.Results) == 1 && isErrorType(v.Results[0].Type) {
+			return derefType(v.Params[0].Type), true
+		}
+	}
+	return nil, false
+}
+
+// serverStreamElems returns the values a streaming result yields: the
+// element of a channel or iter.Seq, or the pair of an iter.Seq2 whose
+// second element is not an error.
+func serverStreamElems(goType parser.GoType) ([]parser.GoParam, bool) {
+	switch v := goType.(type) {
+	case parser.ChanType:
+		if v.Dir != ast.SEND {
+			return []parser.GoParam{{Name: "value", Type: derefType(v.Elem)}}, true
+		}
+	case parser.NamedType:
+		if v.Package != "iter" {
+			return nil, false
+		}
+		switch {
+		case v.Name == "Seq" && len(v.TypeArgs) == 1:
+			return []parser.GoParam{{Name: "value", Type: derefType(v.TypeArgs[0])}}, true
+		case v.Name == "Seq2" && len(v.TypeArgs) == 2 && isErrorType(v.TypeArgs[1]):
+			return []parser.GoParam{{Name: "value", Type: derefType(v.TypeArgs[0])}}, true
+		case v.Name == "Seq2" && len(v.TypeArgs) == 2:
+			return []parser.GoParam{{Name: "key", Type: v.TypeArgs[0]}, {Name: "value", Type: v.TypeArgs[1]}}, true
+		}
+	}
+	return nil, false
+}
+
+// isIOType reports whether goType is one of the named io interfaces.
+func isIOType(goType parser.GoType, names ...string) bool {
+	named, ok := goType.(parser.NamedType)
+	return ok && named.Package == "io" && slices.Contains(names, named.Name)
+}
+
+func derefType(goType parser.GoType) parser.GoType {
+	if ptr, ok := goType.(parser.PointerType); ok {
+		return ptr.Elem
+	}
+	return goType
+}
+
+func isErrorType(goType parser.GoType) bool {
+	basic, ok := goType.(parser.BasicType)
+	return ok && basic.Name == "error"
+}
+
+// generateChunkMessages synthesizes the message streamed by a byte-stream
+// RPC. When there are other parameters or results, the chunk is a oneof of
+// a header frame carrying them, sent first, and a frame of raw bytes. The
+// chunk message is returned last.
+func (t *Transformer) generateChunkMessages(name string, header []parser.GoParam, fromResults bool, messageName func(string) string, enumLookup map[string]bool) ([]ProtoMessage, []string, []Diagnostic) {
+	chunk := ProtoMessage{Name: messageName(name + "Chunk")}
+	if len(header) == 0 {
+		chunk.Fields = []ProtoField{{Name: "data", Type: "bytes", Number: 1, Optional: t.opts.Syntax == SyntaxProto2}}
+		return []ProtoMessage{chunk}, nil, nil
+	}
+	var headerMsg *ProtoMessage
+	var imports []string
+	var diags []Diagnostic
+	if fromResults {
+		headerMsg, imports, diags = t.generateResponseMessage(messageName(name+"Header"), header, enumLookup)
 	} else {
-		rpc.OutputType = "google.protobuf.Empty"
-		imports = append(imports, "google/protobuf/empty.proto")
+		headerMsg, imports, diags = t.generateRequestMessage(messageName(name+"Header"), header, enumLookup)
+	}
+	chunk.Oneofs = []ProtoOneof{{Name: "frame"}}
+	chunk.Fields = []ProtoField{
+		{Name: "header", Type: headerMsg.Name, Number: 1, Oneof: "frame", Comments: []string{"Sent in the first frame only."}},
+		{Name: "data", Type: "bytes", Number: 2, Oneof: "frame"},
 	}
+	return []ProtoMessage{*headerMsg, chunk}, imports, diags
+}
 
-	return rpc, reqMsg, respMsg, imports
+// messageType returns the message name a parameter or result can be used
+// as directly, or "" if it has to be wrapped in a synthesized message.
+func (t *Transformer) messageType(goType parser.GoType, enumLookup map[string]bool) string {
+	named, ok := goType.(parser.NamedType)
+	if !ok || named.Package != "" || len(named.TypeArgs) > 0 || enumLookup[named.Name] {
+		return ""
+	}
+	if _, isFlags := t.flags[named.Name]; isFlags {
+		return ""
+	}
+	return named.Name
 }
 
-func (t *Transformer) generateRequestMessage(methodName string, params []parser.GoParam) *ProtoMessage {
-	msg := &ProtoMessage{Name: methodName + "Request"}
+// generateRequestMessage synthesizes a request message from method
+// parameters. The fields go through the same pipeline as struct fields;
+// variadic parameters become repeated fields.
+func (t *Transformer) generateRequestMessage(name string, params []parser.GoParam, enumLookup map[string]bool) (*ProtoMessage, []string, []Diagnostic) {
+	fields := make([]parser.GoField, len(params))
 	for i, p := range params {
-		protoType, _, repeated, isMap, mapKey, mapValue := t.transformType(p.Type, nil, nil)
-		name := p.Name
-		if name == "" {
-			name = fmt.Sprintf("arg%d", i+1)
+		fieldName := p.Name
+		if fieldName == "" {
+			fieldName = fmt.Sprintf("arg%d", i+1)
 		}
-		field := ProtoField{Name: toSnakeCase(name), Type: protoType, Number: i + 1, Repeated: repeated}
-		if isMap {
-			field.MapKey = mapKey
-			field.MapValue = mapValue
+		fieldType := p.Type
+		if p.Variadic {
+			fieldType = parser.SliceType{Elem: p.Type}
 		}
-		msg.Fields = append(msg.Fields, field)
+		fields[i] = parser.GoField{Name: fieldName, Type: fieldType, Exported: true}
 	}
-	return msg
+	msg := &ProtoMessage{Name: name}
+	imports, diags := t.transformFields(msg, fields, enumLookup, nil)
+	return msg, ct.Unique(imports), diags
 }
 
-func (t *Transformer) generateResponseMessage(methodName string, results []parser.GoParam) *ProtoMessage {
-	msg := &ProtoMessage{Name: methodName + "Response"}
+// generateResponseMessage synthesizes a response message from method
+// results. Unnamed results are named result1, result2 and so on, or after
+// their type with WrapRPCMessages.
+func (t *Transformer) generateResponseMessage(name string, results []parser.GoParam, enumLookup map[string]bool) (*ProtoMessage, []string, []Diagnostic) {
+	fields := make([]parser.GoField, len(results))
+	used := make(map[string]bool)
 	for i, r := range results {
-		protoType, _, repeated, isMap, mapKey, mapValue := t.transformType(r.Type, nil, nil)
-		name := r.Name
-		if name == "" {
-			name = fmt.Sprintf("result%d", i+1)
+		fieldName := r.Name
+		if fieldName == "" && t.opts.WrapRPCMessages {
+			// Wrapped results are named after their type, e.g. User user = 1.
+			fieldName = localTypeName(r.Type)
 		}
-		field := ProtoField{Name: toSnakeCase(name), Type: protoType, Number: i + 1, Repeated: repeated}
-		if isMap {
-			field.MapKey = mapKey
-			field.MapValue = mapValue
+		if fieldName == "" || used[toSnakeCase(fieldName)] {
+			fieldName = fmt.Sprintf("result%d", i+1)
 		}
-		msg.Fields = append(msg.Fields, field)
+		used[toSnakeCase(fieldName)] = true
+		fields[i] = parser.GoField{Name: fieldName, Type: r.Type, Exported: true}
 	}
-	return msg
+	msg := &ProtoMessage{Name: name}
+	imports, diags := t.transformFields(msg, fields, enumLookup, nil)
+	return msg, ct.Unique(imports), diags
 }
 
 func toProtoPackage(goPath string) string {
@@ -543,6 +1510,12 @@ func parseProtobufTag(tag string) *ProtoField {
 		if part == "opt" {
 			field.Optional = true
 		}
+		if part == "req" {
+			field.Required = true
+		}
+		if def, ok := strings.CutPrefix(part, "def="); ok {
+			field.Default = &OptionValue{Value: def}
+		}
 	}
 	switch parts[0] {
 	case "bytes":
@@ -563,6 +1536,77 @@ func filterNonTagComments(comments []string) []string {
 	})
 }
 
+// canBeOneofMember reports whether a field may appear inside a oneof;
+// protobuf forbids repeated and map fields there.
+func canBeOneofMember(f ProtoField) bool {
+	return !f.Repeated && f.MapKey == ""
+}
+
+func hasOneof(oneofs []ProtoOneof, name string) bool {
+	for _, o := range oneofs {
+		if o.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func aliasLookup(pkg parser.GoPackage) map[string]parser.GoAlias {
+	lookup := make(map[string]parser.GoAlias)
+	for _, alias := range pkg.Aliases {
+		lookup[alias.Name] = alias
+	}
+	return lookup
+}
+
+// enumPos places an enum at its type declaration, or at its first
+// constant when the type is declared elsewhere.
+func enumPos(cg parser.GoConstGroup, alias parser.GoAlias) token.Position {
+	if alias.Pos.IsValid() {
+		return alias.Pos
+	}
+	return cg.Pos
+}
+
+// isPowerOfTwoSet reports whether the constants are three or more
+// distinct powers of two, optionally alongside a zero value.
+func isPowerOfTwoSet(values []parser.GoConstValue) bool {
+	seen := make(map[int64]bool)
+	for _, v := range values {
+		if v.Value == 0 {
+			continue
+		}
+		if v.Value < 0 || v.Value&(v.Value-1) != 0 || seen[v.Value] {
+			return false
+		}
+		seen[v.Value] = true
+	}
+	return len(seen) >= 3
+}
+
+func hasEnumNumber(values []ProtoEnumValue, number int) bool {
+	for _, v := range values {
+		if v.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// localTypeName returns the name of a package-local named type, looking
+// through pointers, or "" for anything else.
+func localTypeName(goType parser.GoType) string {
+	switch v := goType.(type) {
+	case parser.NamedType:
+		if v.Package == "" {
+			return v.Name
+		}
+	case parser.PointerType:
+		return localTypeName(v.Elem)
+	}
+	return ""
+}
+
 func isBasicProtoType(t string) bool {
 	switch t {
 	case "string", "bool", "bytes", "int32", "int64", "uint32", "uint64",
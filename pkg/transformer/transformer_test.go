@@ -0,0 +1,124 @@
+package transformer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vinodhalaharvi/go2proto/pkg/parser"
+)
+
+func TestFlagEnumDropsHighBit(t *testing.T) {
+	pkg := parser.GoPackage{
+		Name: "files",
+		Path: "example.com/files",
+		Aliases: []parser.GoAlias{
+			{Name: "Perm", Underlying: parser.BasicType{Name: "uint32"}},
+			{Name: "Wide", Underlying: parser.BasicType{Name: "uint64"}},
+		},
+		Consts: []parser.GoConstGroup{
+			{TypeName: "Perm", Values: []parser.GoConstValue{
+				{Name: "PermRead", Value: 1},
+				{Name: "PermWrite", Value: 2},
+				{Name: "PermExec", Value: 4},
+				{Name: "PermAll", Value: 1 << 31},
+			}},
+			{TypeName: "Wide", Values: []parser.GoConstValue{
+				{Name: "WideA", Value: 1},
+				{Name: "WideB", Value: 2},
+				{Name: "WideC", Value: 4},
+				{Name: "WideTop", Overflow: true},
+			}},
+		},
+	}
+	p := NewTransformer(DefaultOptions()).Transform([]parser.GoPackage{pkg})
+
+	want := map[string][]string{
+		"Perm": {"PERM_UNSPECIFIED", "PERM_READ", "PERM_WRITE", "PERM_EXEC"},
+		"Wide": {"WIDE_UNSPECIFIED", "WIDE_A", "WIDE_B", "WIDE_C"},
+	}
+	if len(p.Enums) != len(want) {
+		t.Fatalf("%d enums, want %d", len(p.Enums), len(want))
+	}
+	for _, enum := range p.Enums {
+		var names []string
+		for _, v := range enum.Values {
+			names = append(names, v.Name)
+		}
+		if got := strings.Join(names, " "); got != strings.Join(want[enum.Name], " ") {
+			t.Errorf("enum %s values = %s, want %s", enum.Name, got, strings.Join(want[enum.Name], " "))
+		}
+	}
+
+	for _, name := range []string{"PERM_ALL", "WIDE_TOP"} {
+		found := false
+		for _, d := range p.Diagnostics {
+			if d.Severity == SeverityWarning && strings.Contains(d.Message, "dropped "+name) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no warning for %s in %v", name, p.Diagnostics)
+		}
+	}
+}
+
+func TestFlagEnumProto2HasNoZeroValue(t *testing.T) {
+	pkg := parser.GoPackage{
+		Name:    "files",
+		Path:    "example.com/files",
+		Aliases: []parser.GoAlias{{Name: "Perm", Underlying: parser.BasicType{Name: "uint32"}}},
+		Consts: []parser.GoConstGroup{{TypeName: "Perm", Values: []parser.GoConstValue{
+			{Name: "PermRead", Value: 1},
+			{Name: "PermWrite", Value: 2},
+			{Name: "PermExec", Value: 4},
+		}}},
+	}
+	opts := DefaultOptions()
+	opts.Syntax = SyntaxProto2
+	p := NewTransformer(opts).Transform([]parser.GoPackage{pkg})
+
+	if len(p.Enums) != 1 {
+		t.Fatalf("%d enums, want 1", len(p.Enums))
+	}
+	if values := p.Enums[0].Values; len(values) != 3 || values[0].Name != "PERM_READ" {
+		t.Errorf("values = %v, want PERM_READ, PERM_WRITE, PERM_EXEC", values)
+	}
+}
+
+func TestOneofSkipsRepeatedFields(t *testing.T) {
+	oneof := map[string]string{"go2proto:oneof": "choice"}
+	pkg := parser.GoPackage{
+		Name: "picks",
+		Path: "example.com/picks",
+		Structs: []parser.GoStruct{{Name: "Pick", Fields: []parser.GoField{
+			{Name: "Name", Type: parser.BasicType{Name: "string"}, Exported: true, Tags: oneof},
+			{Name: "Tags", Type: parser.SliceType{Elem: parser.BasicType{Name: "string"}}, Exported: true, Tags: oneof},
+		}}},
+	}
+	p := NewTransformer(DefaultOptions()).Transform([]parser.GoPackage{pkg})
+
+	if len(p.Messages) != 1 {
+		t.Fatalf("%d messages, want 1", len(p.Messages))
+	}
+	for _, f := range p.Messages[0].Fields {
+		if want := map[string]string{"name": "choice"}[f.Name]; f.Oneof != want {
+			t.Errorf("field %s oneof = %q, want %q", f.Name, f.Oneof, want)
+		}
+	}
+	if len(p.Diagnostics) != 1 || !strings.Contains(p.Diagnostics[0].Message, "left out of oneof choice") {
+		t.Errorf("diagnostics = %v, want a warning for tags", p.Diagnostics)
+	}
+}
+
+func TestManagedObjcClassPrefix(t *testing.T) {
+	for pkg, want := range map[string]string{
+		"go.v1":          "GXX",
+		"acme.users.v1":  "AUX",
+		"acme.users.api": "AUA",
+		"google.pb.b":    "GPX",
+	} {
+		if got := managedOptions(pkg)["objc_class_prefix"].Value; got != want {
+			t.Errorf("objc_class_prefix for %s = %q, want %q", pkg, got, want)
+		}
+	}
+}